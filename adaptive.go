@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/stats"
+	"github.com/gdanko/wsstats/util"
+)
+
+// How much the intervals are stretched in the "slow" idle mode unless configured
+var defaultIdleFactor = 10.0
+
+// MarkRead records that a client read the snapshot and lets the run loop speed collection back up
+func (w *Wezterm) MarkRead() {
+	w.LastRead.Store(time.Now().UnixNano())
+	select {
+	case w.Activity <- struct{}{}:
+	default:
+	}
+}
+
+// checkOutputRead tells whether the output file was read since it was last written. A read updates
+// the access time past the modification time, also on filesystems mounted with relatime.
+func (w *Wezterm) checkOutputRead() {
+	info, err := os.Stat(w.OutputFile)
+	if err != nil {
+		return
+	}
+	if util.FileAccessTime(info).After(info.ModTime()) {
+		w.LastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// AdaptPace slows the collectors down or pauses them depending on when the output was last read
+// and on the power source
+func (w *Wezterm) AdaptPace() {
+	var (
+		factor  float64 = 1
+		paused  bool
+		reasons []string
+	)
+
+	adaptive := w.Config.Adaptive
+	if adaptive.BatteryFactor > 1 {
+		onBattery, err := stats.OnBattery()
+		if err != nil {
+			w.Logger.Warnf("failed to determine the power source: %s", err.Error())
+		} else if onBattery {
+			factor *= adaptive.BatteryFactor
+			reasons = append(reasons, "running on battery")
+		}
+	}
+
+	if w.IdleAfter > 0 {
+		idle := time.Since(time.Unix(0, w.LastRead.Load()))
+		if idle >= w.IdleAfter {
+			reasons = append(reasons, "no reads for "+idle.Round(time.Second).String())
+			if adaptive.IdleMode == "pause" {
+				paused = true
+			} else if adaptive.IdleFactor > 0 {
+				factor *= adaptive.IdleFactor
+			} else {
+				factor *= defaultIdleFactor
+			}
+		}
+	}
+
+	previousFactor, previousPaused := w.Scheduler.Pace()
+	if factor == previousFactor && paused == previousPaused {
+		return
+	}
+	w.Scheduler.SetPace(factor, paused)
+	switch {
+	case paused:
+		w.Logger.Infof("Pausing collection: %s", strings.Join(reasons, ", "))
+	case factor > 1:
+		w.Logger.Infof("Slowing collection down %gx: %s", factor, strings.Join(reasons, ", "))
+	default:
+		w.Logger.Info("Resuming collection at the configured intervals")
+	}
+}
@@ -0,0 +1,534 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gdanko/wsstats/config"
+	test_runner "github.com/gdanko/wsstats/gather"
+	"github.com/gdanko/wsstats/stats"
+	"github.com/gdanko/wsstats/util"
+)
+
+type collectorDefinition struct {
+	Name     string
+	Interval time.Duration
+	// Collectors that are not enabled by default have to be selected on the command line or in
+	// the configuration file
+	Default bool
+	// New returns the collect function of a fresh collector and, for collectors holding descriptors
+	// or other resources, a function releasing them once the collector is dropped
+	New func(w *Wezterm) (collect func() (interface{}, error), close func(), err error)
+	// Baseline is set for collectors computing rates from the difference with their previous run.
+	// Their first run only takes a sample, the scheduler reports a second run made CpuInterval
+	// later, which is what gives them values in single-shot mode.
+	Baseline bool
+}
+
+// collectorDefinitions lists every collector wsstats knows about
+var collectorDefinitions = []collectorDefinition{
+	{
+		Name: "battery", Interval: 5 * time.Second, Default: false,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			powerSupplies := &stats.PowerSupplies{}
+			return func() (interface{}, error) {
+				return powerSupplies.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "cgroup", Interval: 2 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			cgroups := &stats.Cgroups{Breakdown: w.Config.Cgroups.Breakdown}
+			return func() (interface{}, error) {
+				return cgroups.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "containers", Interval: 5 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			containers := &stats.Containers{Socket: w.Config.Containers.Socket}
+			return func() (interface{}, error) {
+				return containers.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "cpu", Interval: 1 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				cpuPercent, err := stats.GetCpuPercent(false, w.CpuInterval)
+				if err != nil || len(cpuPercent) == 0 {
+					return cpuPercent, err
+				}
+				cpuPercent[0].Frequency, err = stats.GetCpuFrequency()
+				return cpuPercent, err
+			}, nil, nil
+		},
+	},
+	{
+		Name: "disk", Interval: 30 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetDiskUsage()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "events", Interval: 5 * time.Second, Default: false,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			kernelEvents := &stats.KernelEvents{Recent: 20}
+			return func() (interface{}, error) {
+				return kernelEvents.Collect()
+			}, kernelEvents.Close, nil
+		},
+	},
+	{
+		Name: "host", Interval: 5 * time.Minute, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetHostInformation()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "load", Interval: 1 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetLoadAverages()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "memory", Interval: 1 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetMemoryUsage()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "memory_detail", Interval: 2 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			memoryDetail := &stats.MemoryDetail{}
+			return func() (interface{}, error) {
+				return memoryDetail.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "network", Interval: 1 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			networkThroughput := &test_runner.NetworkThroughput{Logger: w.Logger, Baseline: w.CpuInterval}
+			return func() (interface{}, error) {
+				interfaces, err := networkThroughput.Collect()
+				if err != nil {
+					return interfaces, err
+				}
+				// The throughput is still worth reporting when the wireless links cannot be read
+				wireless, _ := stats.GetWirelessData()
+				for i := range interfaces {
+					for j := range wireless {
+						if wireless[j].Interface == interfaces[i].Interface {
+							interfaces[i].Wifi = &wireless[j]
+						}
+					}
+				}
+				return interfaces, nil
+			}, nil, nil
+		},
+	},
+	{
+		Name: "network_context", Interval: 30 * time.Second, Default: false,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetNetworkContext()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "pressure", Interval: 2 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			pressure := &stats.Pressure{Cgroups: w.Config.Pressure.Cgroups}
+			return func() (interface{}, error) {
+				return pressure.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "probes", Interval: 10 * time.Second, Default: false,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			probes := &stats.Probes{Window: w.Config.Probes.Window}
+			names := make(map[string]bool)
+			for _, target := range w.Config.Probes.Targets {
+				probe, err := newProbe(target)
+				if err != nil {
+					return nil, nil, err
+				}
+				if names[probe.Name] {
+					return nil, nil, fmt.Errorf("more than one probe is named \"%s\"", probe.Name)
+				}
+				names[probe.Name] = true
+				probes.Probes = append(probes.Probes, probe)
+			}
+			return func() (interface{}, error) {
+				return probes.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "processes", Interval: 3 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			topProcesses := &stats.TopProcesses{Count: 5, CmdlineLength: 80}
+			if w.Config.Processes.Count > 0 {
+				topProcesses.Count = w.Config.Processes.Count
+			}
+			if w.Config.Processes.CmdlineLength > 0 {
+				topProcesses.CmdlineLength = w.Config.Processes.CmdlineLength
+			}
+			return func() (interface{}, error) {
+				return topProcesses.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "rapl", Interval: 2 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			rapl := &stats.Rapl{}
+			return func() (interface{}, error) {
+				return rapl.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "sockets", Interval: 5 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			sockets := &stats.Sockets{}
+			return func() (interface{}, error) {
+				return sockets.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "swap", Interval: 1 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			return func() (interface{}, error) {
+				return stats.GetSwapUsage()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "temperature", Interval: 5 * time.Second, Default: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			temperatures := &stats.Temperatures{CPUSensor: w.Config.Temperature.CPUSensor}
+			return func() (interface{}, error) {
+				return temperatures.Collect()
+			}, nil, nil
+		},
+	},
+	{
+		Name: "textfile", Interval: 5 * time.Second, Default: false,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			textfiles, err := newTextfiles(w.Config.Textfile)
+			if err != nil {
+				return nil, nil, err
+			}
+			return func() (interface{}, error) {
+				return textfiles.Collect()
+			}, textfiles.Close, nil
+		},
+	},
+	{
+		Name: "watches", Interval: 5 * time.Second, Default: false, Baseline: true,
+		New: func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+			processWatches := &stats.ProcessWatches{}
+			for _, watch := range w.Config.Watches {
+				processWatch, err := newProcessWatch(watch)
+				if err != nil {
+					return nil, nil, err
+				}
+				processWatches.Watches = append(processWatches.Watches, processWatch)
+			}
+			return func() (interface{}, error) {
+				return processWatches.Collect()
+			}, nil, nil
+		},
+	},
+}
+
+// snapshotKeys are the keys of the snapshot that are not collector sections
+var snapshotKeys = []string{"collect_durations", "collected_at", "collectors", "errors", "pid", "run_time", "start_time", "timestamp", "version"}
+
+var execNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func findCollectorDefinition(definitions []collectorDefinition, name string) (definition collectorDefinition, found bool) {
+	for _, definition = range definitions {
+		if definition.Name == name {
+			return definition, true
+		}
+	}
+	return definition, false
+}
+
+// SelectCollectors enables the sections chosen on the command line, or else the ones listed in
+// the configuration file, or else the default ones
+func (w *Wezterm) SelectCollectors() (err error) {
+	definitions, err := w.definitions()
+	if err != nil {
+		return err
+	}
+
+	selected := make(map[string]bool)
+	for _, collector := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"cpu", w.CPU},
+		{"disk", w.Disk},
+		{"host", w.Host},
+		{"load", w.Load},
+		{"memory", w.Memory},
+		{"network", w.Net},
+		{"swap", w.Swap},
+	} {
+		if collector.enabled {
+			selected[collector.name] = true
+		}
+	}
+	for _, name := range w.CollectorNames {
+		selected[name] = true
+	}
+
+	source := "on the command line"
+	if len(selected) == 0 && !w.All {
+		for _, name := range w.Config.Collectors {
+			selected[name] = true
+		}
+		source = fmt.Sprintf("in the configuration file \"%s\"", w.ConfigFile)
+	}
+	if len(selected) == 0 || w.All {
+		for _, definition := range definitions {
+			if definition.Default {
+				selected[definition.Name] = true
+			}
+		}
+	}
+
+	var unknown []string
+	for name := range selected {
+		if _, found := findCollectorDefinition(definitions, name); !found {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown collector \"%s\" %s", unknown[0], source)
+	}
+
+	w.Enabled = nil
+	for _, definition := range definitions {
+		if selected[definition.Name] {
+			w.Enabled = append(w.Enabled, definition.Name)
+		}
+	}
+	return nil
+}
+
+// Interval returns how often a collector runs, the command line taking precedence over the
+// configuration file
+func (w *Wezterm) Interval(definition collectorDefinition) (interval time.Duration, err error) {
+	value, ok := w.Intervals[definition.Name]
+	if !ok {
+		value, ok = w.Config.Intervals[definition.Name]
+	}
+	if !ok {
+		return definition.Interval, nil
+	}
+	interval, err = util.ParseDuration(value)
+	if err != nil {
+		return interval, fmt.Errorf("the interval of the %s collector: %s", definition.Name, err.Error())
+	}
+	if interval <= 0 {
+		return interval, fmt.Errorf("the interval of the %s collector must be positive", definition.Name)
+	}
+	return interval, nil
+}
+
+// Collectors builds the enabled collectors. Each call returns fresh collectors, so stateful ones
+// such as network start over from a new baseline.
+func (w *Wezterm) Collectors() (collectors []test_runner.Collector, err error) {
+	definitions, err := w.definitions()
+	if err != nil {
+		return collectors, err
+	}
+	for _, name := range w.Enabled {
+		definition, _ := findCollectorDefinition(definitions, name)
+		interval, err := w.Interval(definition)
+		if err != nil {
+			return collectors, err
+		}
+		collect, closeCollector, err := definition.New(w)
+		if err != nil {
+			return collectors, fmt.Errorf("the %s collector: %s", definition.Name, err.Error())
+		}
+		collector := test_runner.Collector{
+			Name:     definition.Name,
+			Interval: interval,
+			Collect:  collect,
+			Close:    closeCollector,
+		}
+		if definition.Baseline {
+			collector.Baseline = w.CpuInterval
+		}
+		collectors = append(collectors, collector)
+	}
+	return collectors, nil
+}
+
+// definitions returns the built-in collectors followed by the exec collectors of the
+// configuration, which run along with the default collectors
+func (w *Wezterm) definitions() (definitions []collectorDefinition, err error) {
+	definitions = append(definitions, collectorDefinitions...)
+	for _, execConfig := range w.Config.Exec {
+		definition, err := newExecDefinition(execConfig)
+		if err != nil {
+			return definitions, err
+		}
+		if _, found := findCollectorDefinition(definitions, definition.Name); found {
+			return definitions, fmt.Errorf("the exec collector \"%s\" has the name of another collector", definition.Name)
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions, nil
+}
+
+func newExecDefinition(execConfig config.Exec) (definition collectorDefinition, err error) {
+	if !execNamePattern.MatchString(execConfig.Name) {
+		return definition, fmt.Errorf("invalid exec collector name \"%s\", use letters, digits, \"_\" and \"-\"", execConfig.Name)
+	}
+	for _, key := range snapshotKeys {
+		if execConfig.Name == key {
+			return definition, fmt.Errorf("the exec collector name \"%s\" is reserved", execConfig.Name)
+		}
+	}
+	if len(execConfig.Command) == 0 || execConfig.Command[0] == "" {
+		return definition, fmt.Errorf("the exec collector \"%s\" needs a command", execConfig.Name)
+	}
+	switch execConfig.Format {
+	case "", "json", "keyvalue":
+	default:
+		return definition, fmt.Errorf("invalid format \"%s\" of the exec collector \"%s\", use \"json\" or \"keyvalue\"", execConfig.Format, execConfig.Name)
+	}
+
+	command := &stats.Command{Args: execConfig.Command, Format: execConfig.Format, Timeout: 10 * time.Second}
+	definition = collectorDefinition{Name: execConfig.Name, Interval: 30 * time.Second, Default: true}
+	for _, setting := range []struct {
+		name   string
+		value  string
+		target *time.Duration
+	}{
+		{"interval", execConfig.Interval, &definition.Interval},
+		{"timeout", execConfig.Timeout, &command.Timeout},
+	} {
+		if setting.value == "" {
+			continue
+		}
+		duration, err := util.ParseDuration(setting.value)
+		if err != nil || duration <= 0 {
+			return definition, fmt.Errorf("invalid %s \"%s\" of the exec collector \"%s\"", setting.name, setting.value, execConfig.Name)
+		}
+		*setting.target = duration
+	}
+
+	definition.New = func(w *Wezterm) (collect func() (interface{}, error), close func(), err error) {
+		// Every instance of the collector gets its own copy as the command has no state to share
+		command := *command
+		return func() (interface{}, error) {
+			return command.Collect()
+		}, nil, nil
+	}
+	return definition, nil
+}
+
+func newProcessWatch(watch config.Watch) (processWatch stats.ProcessWatch, err error) {
+	if watch.Name == "" {
+		return processWatch, fmt.Errorf("every watch needs a name")
+	}
+	criteria := 0
+	for _, criterion := range []string{watch.Process, watch.Cmdline, watch.PIDFile, watch.Unit} {
+		if criterion != "" {
+			criteria++
+		}
+	}
+	if criteria != 1 {
+		return processWatch, fmt.Errorf("the watch \"%s\" needs exactly one of process, cmdline, pid_file and unit", watch.Name)
+	}
+
+	processWatch = stats.ProcessWatch{
+		Name:    watch.Name,
+		Process: watch.Process,
+		PIDFile: watch.PIDFile,
+		Unit:    watch.Unit,
+	}
+	if watch.Cmdline != "" {
+		processWatch.Cmdline, err = regexp.Compile(watch.Cmdline)
+		if err != nil {
+			return processWatch, fmt.Errorf("the watch \"%s\" has an invalid cmdline pattern: %s", watch.Name, err.Error())
+		}
+	}
+	return processWatch, nil
+}
+
+func newProbe(target config.Probe) (probe stats.Probe, err error) {
+	if target.Name == "" {
+		return probe, fmt.Errorf("every probe needs a name")
+	}
+	if (target.Address == "") == (target.URL == "") {
+		return probe, fmt.Errorf("the probe \"%s\" needs exactly one of address and url", target.Name)
+	}
+	if target.Address != "" {
+		if _, _, err = net.SplitHostPort(target.Address); err != nil {
+			return probe, fmt.Errorf("the probe \"%s\" has an invalid address: %s", target.Name, err.Error())
+		}
+	}
+	if target.URL != "" {
+		parsed, err := url.Parse(target.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return probe, fmt.Errorf("the probe \"%s\" needs an http or https url", target.Name)
+		}
+	}
+
+	probe = stats.Probe{Name: target.Name, Address: target.Address, URL: target.URL}
+	if target.Timeout != "" {
+		probe.Timeout, err = util.ParseDuration(target.Timeout)
+		if err != nil || probe.Timeout <= 0 {
+			return probe, fmt.Errorf("the probe \"%s\" has an invalid timeout \"%s\"", target.Name, target.Timeout)
+		}
+	}
+	return probe, nil
+}
+
+// newTextfiles defaults the directory to textfile under the data directory, shared by every instance
+func newTextfiles(textfileConfig config.Textfile) (textfiles *stats.Textfiles, err error) {
+	textfiles = &stats.Textfiles{Directory: textfileConfig.Directory, StaleAfter: time.Hour}
+	if textfiles.Directory == "" {
+		dataDir, err := util.GetDataDir()
+		if err != nil {
+			return nil, err
+		}
+		textfiles.Directory = filepath.Join(dataDir, "textfile")
+	}
+	if textfileConfig.StaleAfter != "" {
+		textfiles.StaleAfter, err = util.ParseDuration(textfileConfig.StaleAfter)
+		if err != nil || textfiles.StaleAfter < 0 {
+			return nil, fmt.Errorf("invalid textfile stale_after \"%s\"", textfileConfig.StaleAfter)
+		}
+	}
+	return textfiles, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/selector"
+	"github.com/gdanko/wsstats/util"
+)
+
+// How long rate metrics are sampled for in single-shot mode
+var onceBaseline = 500 * time.Millisecond
+
+type GetCommand struct {
+	w      *Wezterm
+	Format string `short:"f" long:"format" default:"raw" choice:"raw" choice:"json" description:"Output format, raw prints strings and numbers as they are"`
+	Args   struct {
+		Path string `positional-arg-name:"path" description:"Path to the value, e.g. memory.used_percent or network[interface=wlan0].bytes_recv_per_sec, camelCase keys can be given in snake_case"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+func (c *GetCommand) Execute(args []string) error {
+	snapshot, err := c.w.ReadSnapshot()
+	if err != nil {
+		return err
+	}
+
+	var data interface{}
+	err = json.Unmarshal(snapshot, &data)
+	if err != nil {
+		return fmt.Errorf("failed to parse the snapshot: %s", err.Error())
+	}
+
+	value, err := selector.Select(data, c.Args.Path)
+	if err != nil {
+		return err
+	}
+
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		c.Format = "json"
+	}
+	if c.Format == "json" {
+		jsonBytes, err := json.MarshalIndent(value, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+		return nil
+	}
+	fmt.Fprintln(os.Stdout, selector.Format(value))
+	return nil
+}
+
+type OnceCommand struct {
+	w      *Wezterm
+	Format string `short:"f" long:"format" default:"json" choice:"json" choice:"metrics" description:"Output format, metrics prints only the numbers kept in the history as name value lines, leaving out text such as host names or process lists"`
+}
+
+func (c *OnceCommand) Execute(args []string) error {
+	w := c.w
+	// Rate metrics such as CPU percent and network throughput sample for the baseline period,
+	// all collectors run at the same time so that is also about how long this takes
+	w.CpuInterval = onceBaseline
+	collectors, err := w.Collectors()
+	if err != nil {
+		return err
+	}
+	w.Scheduler.CollectOnce(collectors)
+
+	output, errs := w.Snapshot()
+	switch c.Format {
+	case "json":
+		jsonBytes, err := json.MarshalIndent(output, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+	case "metrics":
+		// Only the numbers, as they would be recorded in the history
+		samples := flattenSamples(output)
+		metrics := make([]string, 0, len(samples))
+		for metric := range samples {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			fmt.Fprintf(os.Stdout, "%s %s\n", metric, strconv.FormatFloat(samples[metric], 'f', -1, 64))
+		}
+	}
+
+	if len(errs) > 0 {
+		sections := make([]string, 0, len(errs))
+		for section := range errs {
+			sections = append(sections, section)
+		}
+		sort.Strings(sections)
+		return fmt.Errorf("failed to collect: %s", strings.Join(sections, ", "))
+	}
+	return nil
+}
+
+type QueryCommand struct {
+	w     *Wezterm
+	Since string `long:"since" default:"1h" description:"How far back to query, e.g. 15m, 24h or 7d"`
+	List  bool   `long:"list" description:"List the recorded metrics matching the pattern, or all of them, instead of their values"`
+	Args  struct {
+		Metric string `positional-arg-name:"metric" description:"Metric name or pattern where * also matches the / of mount points, e.g. cpu.total, network.*.bytes_recv or disk.*.used_percent"`
+	} `positional-args:"yes"`
+}
+
+func (c *QueryCommand) Execute(args []string) error {
+	s, err := c.w.OpenStore()
+	if err != nil {
+		return err
+	}
+
+	if c.List {
+		metrics, err := s.Metrics(c.Args.Metric)
+		if err != nil {
+			return err
+		}
+		for _, metric := range metrics {
+			fmt.Fprintln(os.Stdout, metric)
+		}
+		return nil
+	}
+
+	if c.Args.Metric == "" {
+		return fmt.Errorf("a metric name is required, use --list to see the recorded metrics")
+	}
+
+	since, err := util.ParseDuration(c.Since)
+	if err != nil {
+		return err
+	}
+
+	samples, err := s.Query(c.Args.Metric, since, util.GetTimestamp())
+	if err != nil {
+		return err
+	}
+	if len(samples) == 0 {
+		return fmt.Errorf("no samples of \"%s\" were recorded in the last %s", c.Args.Metric, c.Since)
+	}
+	for _, sample := range samples {
+		timestamp := time.Unix(int64(sample.Timestamp), 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(os.Stdout, "%s %s %s\n", timestamp, sample.Metric, strconv.FormatFloat(util.RoundTo(sample.Value, 2), 'f', -1, 64))
+	}
+	return nil
+}
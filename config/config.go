@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// Config is read from $XDG_CONFIG_HOME/wsstats/<instance>.json. Every field is optional and the
+// command line options take precedence over it.
+type Config struct {
+	Collectors  []string          `json:"collectors"`
+	Intervals   map[string]string `json:"intervals"`
+	OutputFile  string            `json:"output_file"`
+	Logfile     string            `json:"log_file"`
+	NoHistory   bool              `json:"no_history"`
+	Adaptive    Adaptive          `json:"adaptive"`
+	Processes   Processes         `json:"processes"`
+	Watches     []Watch           `json:"watches"`
+	Pressure    Pressure          `json:"pressure"`
+	Temperature Temperature       `json:"temperature"`
+	Cgroups     Cgroups           `json:"cgroups"`
+	Containers  Containers        `json:"containers"`
+	Probes      Probes            `json:"probes"`
+	Exec        []Exec            `json:"exec"`
+	Textfile    Textfile          `json:"textfile"`
+	Metrics     Metrics           `json:"metrics"`
+}
+
+// Metrics configures the HTTP endpoint serving the snapshot in the Prometheus text format
+type Metrics struct {
+	// Listen is the address to serve /metrics on, e.g. 127.0.0.1:9101, empty disables it
+	Listen string `json:"listen"`
+}
+
+// Textfile configures the directory of *.json and *.prom files other tools write their metrics
+// to and how long until a file that was not updated is stale
+type Textfile struct {
+	Directory  string `json:"directory"`
+	StaleAfter string `json:"stale_after"`
+}
+
+// Exec defines a collector that runs a command and puts its output in the snapshot under Name
+type Exec struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"`
+	// Format is "json", the default, or "keyvalue" for key=value lines
+	Format   string `json:"format"`
+	Interval string `json:"interval"`
+	Timeout  string `json:"timeout"`
+}
+
+// Probes lists the targets to check and how many checks the success ratio covers
+type Probes struct {
+	Window  int     `json:"window"`
+	Targets []Probe `json:"targets"`
+}
+
+// Probe is a TCP connect check of an address such as localhost:5432 or an HTTP GET of a URL
+type Probe struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	URL     string `json:"url"`
+	Timeout string `json:"timeout"`
+}
+
+// Containers configures the Docker or Podman API socket, found among the usual locations when empty
+type Containers struct {
+	Socket string `json:"socket"`
+}
+
+// Cgroups lists the cgroups reported next to the one wsstats runs in, as paths relative to the
+// cgroup root or unit names
+type Cgroups struct {
+	Breakdown []string `json:"breakdown"`
+}
+
+// Temperature selects the sensor reported as the CPU temperature, as "chip/label" or "chip"
+type Temperature struct {
+	CPUSensor string `json:"cpu_sensor"`
+}
+
+// Pressure configures the cgroups whose pressure is reported next to the system wide one
+type Pressure struct {
+	Cgroups []string `json:"cgroups"`
+}
+
+// Watch names a set of processes to report on, matched by exactly one of the criteria
+type Watch struct {
+	Name    string `json:"name"`
+	Process string `json:"process"`
+	Cmdline string `json:"cmdline"`
+	PIDFile string `json:"pid_file"`
+	Unit    string `json:"unit"`
+}
+
+// Processes configures the top processes collector
+type Processes struct {
+	Count         int `json:"count"`
+	CmdlineLength int `json:"cmdline_length"`
+}
+
+// Adaptive slows collection down when nobody reads the output and while running on battery
+type Adaptive struct {
+	// How long without a read of the output file or socket before the instance is idle, empty disables it
+	IdleAfter string `json:"idle_after"`
+	// "slow" multiplies every interval by IdleFactor, "pause" stops collecting until the next read
+	IdleMode   string  `json:"idle_mode"`
+	IdleFactor float64 `json:"idle_factor"`
+	// Every interval is multiplied by BatteryFactor while running on battery, 0 or 1 disables it
+	BatteryFactor float64 `json:"battery_factor"`
+}
+
+// Validate checks the adaptive settings and returns the parsed idle period, zero when disabled
+func (a Adaptive) Validate() (idleAfter time.Duration, err error) {
+	switch a.IdleMode {
+	case "", "slow", "pause":
+	default:
+		return idleAfter, fmt.Errorf("invalid adaptive idle_mode \"%s\", use \"slow\" or \"pause\"", a.IdleMode)
+	}
+	if a.IdleFactor < 0 || a.BatteryFactor < 0 {
+		return idleAfter, fmt.Errorf("the adaptive factors can not be negative")
+	}
+	if a.IdleAfter == "" {
+		return 0, nil
+	}
+	idleAfter, err = util.ParseDuration(a.IdleAfter)
+	if err != nil {
+		return idleAfter, fmt.Errorf("invalid adaptive idle_after: %s", err.Error())
+	}
+	if idleAfter <= 0 {
+		return idleAfter, fmt.Errorf("the adaptive idle_after must be positive")
+	}
+	return idleAfter, nil
+}
+
+// DefaultPath returns the configuration file of the named instance
+func DefaultPath(instance string) (path string, err error) {
+	configDir, err := util.GetConfigDir()
+	if err != nil {
+		return path, err
+	}
+	return filepath.Join(configDir, instance+".json"), nil
+}
+
+// Load reads the configuration file at path. A missing file is not an error and results in an
+// empty configuration.
+func Load(path string) (config Config, err error) {
+	jsonBytes, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read the configuration file \"%s\": %s", path, err.Error())
+	}
+	err = json.Unmarshal(jsonBytes, &config)
+	if err != nil {
+		return config, fmt.Errorf("failed to parse the configuration file \"%s\": %s", path, err.Error())
+	}
+	return config, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gdanko/wsstats/lock"
+)
+
+// How long stop waits for the running instance to exit
+var stopTimeout = 10 * time.Second
+
+type StatusCommand struct {
+	w *Wezterm
+}
+
+type StopCommand struct {
+	w *Wezterm
+}
+
+type ReloadCommand struct {
+	w *Wezterm
+}
+
+type RestartCommand struct {
+	w *Wezterm
+}
+
+// RunningInstance returns the lockfile contents of the running instance. Only a lockfile that is
+// still held is trusted, so a recycled PID from a stale lockfile is never signalled.
+func (w *Wezterm) RunningInstance() (info lock.Info, err error) {
+	return lock.Read(w.Lockfile)
+}
+
+func (w *Wezterm) SignalInstance(sig syscall.Signal) (info lock.Info, err error) {
+	info, err = w.RunningInstance()
+	if err != nil {
+		return info, err
+	}
+	err = syscall.Kill(info.PID, sig)
+	if err != nil {
+		return info, fmt.Errorf("failed to send %s to PID %d: %s", sig.String(), info.PID, err.Error())
+	}
+	return info, nil
+}
+
+func (w *Wezterm) StopInstance() (info lock.Info, err error) {
+	info, err = w.SignalInstance(syscall.SIGTERM)
+	if err != nil {
+		return info, err
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for time.Now().Before(deadline) {
+		if !lock.ProcessAlive(info.PID) {
+			return info, nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return info, fmt.Errorf("PID %d did not exit within %s", info.PID, stopTimeout)
+}
+
+func (c *StatusCommand) Execute(args []string) error {
+	info, err := c.w.RunningInstance()
+	if err != nil {
+		return err
+	}
+
+	var status struct {
+		Timestamp        uint64             `json:"timestamp"`
+		CollectDurations map[string]float64 `json:"collect_durations"`
+		Version          string             `json:"version"`
+		Collectors       []string           `json:"collectors"`
+	}
+	snapshot, err := c.w.ReadSnapshot()
+	if err == nil {
+		err = json.Unmarshal(snapshot, &status)
+	}
+
+	// The uptime is taken from the lockfile, the snapshot is not updated while collection is paused
+	started := time.Unix(int64(info.StartTime), 0)
+	fmt.Fprintf(os.Stdout, "wsstats is running (PID %d)\n", info.PID)
+	fmt.Fprintf(os.Stdout, "  version:     %s\n", info.Version)
+	fmt.Fprintf(os.Stdout, "  started:     %s\n", started.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(os.Stdout, "  uptime:      %s\n", time.Since(started).Round(time.Second))
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "  no snapshot available yet: %s\n", err.Error())
+		return nil
+	}
+	names := make([]string, 0, len(status.CollectDurations))
+	for name := range status.CollectDurations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	durations := make([]string, 0, len(names))
+	for _, name := range names {
+		durations = append(durations, fmt.Sprintf("%s %s", name, time.Duration(status.CollectDurations[name]*float64(time.Second)).Round(time.Millisecond)))
+	}
+	fmt.Fprintf(os.Stdout, "  collectors:  %s\n", strings.Join(status.Collectors, ", "))
+	fmt.Fprintf(os.Stdout, "  snapshot:    %s\n", time.Unix(int64(status.Timestamp), 0).Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(os.Stdout, "  last runs:   %s\n", strings.Join(durations, ", "))
+	return nil
+}
+
+func (c *StopCommand) Execute(args []string) error {
+	info, err := c.w.StopInstance()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Stopped wsstats (PID %d)\n", info.PID)
+	return nil
+}
+
+func (c *ReloadCommand) Execute(args []string) error {
+	info, err := c.w.SignalInstance(syscall.SIGHUP)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Reloaded wsstats (PID %d)\n", info.PID)
+	return nil
+}
+
+func (c *RestartCommand) Execute(args []string) error {
+	info, err := c.w.StopInstance()
+	running := err == nil
+	if err != nil {
+		if !errors.Is(err, lock.ErrNotRunning) {
+			return err
+		}
+		// Nothing to stop, start an instance with the options given to this command
+		info.Executable, err = os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to determine the path of the running executable: %s", err.Error())
+		}
+		info.Args = c.w.StartArgs
+	}
+
+	// Start the new instance in its own session so it outlives this command
+	cmd := exec.Command(info.Executable, info.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("failed to start \"%s\": %s", info.Executable, err.Error())
+	}
+	pid := cmd.Process.Pid
+	err = cmd.Process.Release()
+	if err != nil {
+		return err
+	}
+	if !running {
+		fmt.Fprintf(os.Stdout, "Started wsstats (PID %d), it was not running\n", pid)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "Restarted wsstats (PID %d, was %d)\n", pid, info.PID)
+	return nil
+}
@@ -2,105 +2,91 @@ package test_runner
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/gdanko/wsstats/iostat"
 	"github.com/gdanko/wsstats/stats"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/gdanko/wsstats/util"
 	"github.com/sirupsen/logrus"
 )
 
 type NetworkInterfaceData struct {
-	Interface   string  `json:"interface"`
-	BytesRecv   float64 `json:"bytes_recv"`
-	BytesSent   float64 `json:"bytes_sent"`
-	PacketsRecv uint64  `json:"packets_recv"`
-	PacketsSent uint64  `json:"packets_sent"`
+	Interface       string  `json:"interface"`
+	BytesRecv       float64 `json:"bytes_recv"`
+	BytesSent       float64 `json:"bytes_sent"`
+	BytesRecvPerSec float64 `json:"bytes_recv_per_sec"`
+	BytesSentPerSec float64 `json:"bytes_sent_per_sec"`
+	PacketsRecv     uint64  `json:"packets_recv"`
+	PacketsSent     uint64  `json:"packets_sent"`
+	// Wifi is the link of a wireless interface, nil for the other interfaces
+	Wifi *stats.WirelessData `json:"wifi,omitempty"`
 }
 
 type IOStatData struct {
-	Interfaces []iostat.IOStatData `json:"interfaces"`
+	Interfaces  []iostat.IOStatData `json:"interfaces"`
+	CollectedAt time.Time           `json:"collected_at"`
 }
 
-func GetCpuPercent(c chan func() ([]stats.PercentStat, error)) {
-	c <- (func() ([]stats.PercentStat, error) {
-		cpuPercent, err := stats.GetCpuPercent(false)
-		return cpuPercent, err
-	})
+// NetworkThroughput computes the per-interface throughput between two consecutive calls to Collect
+type NetworkThroughput struct {
+	Logger *logrus.Logger
+	// How long the first call waits after taking the initial sample
+	Baseline      time.Duration
+	IostatDataOld IOStatData
 }
 
-func GetDiskUsage(c chan func() ([]stats.DiskUsageData, error)) {
-	c <- (func() ([]stats.DiskUsageData, error) {
-		diskUsage, err := stats.GetDiskUsage()
-		return diskUsage, err
-	})
-}
-
-func GetHostInformation(c chan func() (stats.HostInformation, error)) {
-	c <- (func() (stats.HostInformation, error) {
-		hostInformation, err := stats.GetHostInformation()
-		return hostInformation, err
-	})
-}
-
-func GetLoadAverages(c chan func() (*load.AvgStat, error)) {
-	c <- (func() (*load.AvgStat, error) {
-		loadAverages, err := stats.GetLoadAverages()
-		return loadAverages, err
-	})
-}
-
-func GetMemoryUsage(c chan func() (*mem.VirtualMemoryStat, error)) {
-	c <- (func() (*mem.VirtualMemoryStat, error) {
-		memoryUsage, err := stats.GetMemoryUsage()
-		return memoryUsage, err
-	})
-}
-
-func GetSwapUsage(c chan func() (*mem.SwapMemoryStat, error)) {
-	c <- (func() (*mem.SwapMemoryStat, error) {
-		swapUsage, err := stats.GetSwapUsage()
-		return swapUsage, err
-	})
-}
-
-func GetNetworkThroughput(c chan func(logger *logrus.Logger, iostatDataOld IOStatData) (interfaces []NetworkInterfaceData, iostatDataNew IOStatData, err error)) {
-	c <- func(logger *logrus.Logger, iostatDataOld IOStatData) (interfaces []NetworkInterfaceData, iostatDataNew IOStatData, err error) {
-		data, err := iostat.GetData()
+func (n *NetworkThroughput) Collect() (interfaces []NetworkInterfaceData, err error) {
+	if n.IostatDataOld.CollectedAt.IsZero() {
+		n.IostatDataOld, err = getIOStatData()
 		if err != nil {
-			return interfaces, iostatDataNew, err
+			return interfaces, err
 		}
-		iostatDataNew.Interfaces = data
+		time.Sleep(n.Baseline)
+	}
 
-		for _, iostatBlock := range iostatDataNew.Interfaces {
-			var foundInOld, foundInNew = true, true
+	iostatDataNew, err := getIOStatData()
+	if err != nil {
+		return interfaces, err
+	}
+	iostatDataOld := n.IostatDataOld
+	n.IostatDataOld = iostatDataNew
 
-			interfaceName := iostatBlock.Interface
-			interfaceOld, err := findInterface(interfaceName, iostatDataOld.Interfaces)
-			if err != nil {
-				logger.Warnf("interface \"%s\" not found in the old data set", interfaceName)
-				foundInOld = false
-			}
-			foundInOld = true
+	// The deltas are divided by the time between the samples so that the rates stay
+	// comparable when the collection interval changes
+	elapsed := iostatDataNew.CollectedAt.Sub(iostatDataOld.CollectedAt).Seconds()
 
-			interfaceNew, err := findInterface(interfaceName, iostatDataNew.Interfaces)
-			if err != nil {
-				logger.Warnf("interface \"%s\" not found in the new data set", interfaceName)
-				foundInNew = false
-			}
+	for _, iostatBlock := range iostatDataNew.Interfaces {
+		interfaceName := iostatBlock.Interface
+		interfaceOld, err := findInterface(interfaceName, iostatDataOld.Interfaces)
+		if err != nil {
+			n.Logger.Warnf("interface \"%s\" not found in the old data set", interfaceName)
+			continue
+		}
 
-			if foundInOld && foundInNew {
-				interfaces = append(interfaces, NetworkInterfaceData{
-					Interface:   interfaceNew.Interface,
-					BytesSent:   interfaceNew.BytesSent - interfaceOld.BytesSent,
-					BytesRecv:   interfaceNew.BytesRecv - interfaceOld.BytesRecv,
-					PacketsSent: interfaceNew.PacketsSent - interfaceOld.PacketsSent,
-					PacketsRecv: interfaceNew.PacketsRecv - interfaceOld.PacketsRecv,
-				})
-			}
+		networkInterfaceData := NetworkInterfaceData{
+			Interface:   iostatBlock.Interface,
+			BytesSent:   iostatBlock.BytesSent - interfaceOld.BytesSent,
+			BytesRecv:   iostatBlock.BytesRecv - interfaceOld.BytesRecv,
+			PacketsSent: iostatBlock.PacketsSent - interfaceOld.PacketsSent,
+			PacketsRecv: iostatBlock.PacketsRecv - interfaceOld.PacketsRecv,
 		}
-		return interfaces, iostatDataNew, nil
+		if elapsed > 0 {
+			networkInterfaceData.BytesSentPerSec = util.RoundTo(networkInterfaceData.BytesSent/elapsed, 2)
+			networkInterfaceData.BytesRecvPerSec = util.RoundTo(networkInterfaceData.BytesRecv/elapsed, 2)
+		}
+		interfaces = append(interfaces, networkInterfaceData)
+	}
+	return interfaces, nil
+}
+
+func getIOStatData() (iostatData IOStatData, err error) {
+	data, err := iostat.GetData()
+	if err != nil {
+		return iostatData, err
 	}
+	iostatData.Interfaces = data
+	iostatData.CollectedAt = time.Now()
+	return iostatData, nil
 }
 
 func findInterface(interfaceName string, interfaceList []iostat.IOStatData) (iostatEntry iostat.IOStatData, err error) {
@@ -0,0 +1,316 @@
+package test_runner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Collector gathers one section of the snapshot every Interval
+type Collector struct {
+	Name     string
+	Interval time.Duration
+	Collect  func() (interface{}, error)
+	// Close releases what the collector holds, such as open descriptors, once it stops running.
+	// It is nil for collectors without such resources.
+	Close func()
+	// Baseline is set for collectors computing rates from the difference with their previous run.
+	// Their first run only takes the sample to start from, the first result is that of a second
+	// run Baseline later.
+	Baseline time.Duration
+}
+
+// Result is the latest outcome of a collector. Data and CollectedAt are those of the last
+// successful run, Err is the error of the latest run if it failed.
+type Result struct {
+	Data        interface{}
+	Err         error
+	CollectedAt time.Time
+	Duration    time.Duration
+}
+
+// Scheduler runs every collector in its own goroutine on its own interval and keeps the latest results
+type Scheduler struct {
+	Logger  *logrus.Logger
+	lock    sync.RWMutex
+	results map[string]Result
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	// Every interval is multiplied by factor, paused collectors wait until the pace changes.
+	// The pace channel is closed and replaced on every change to wake the waiting collectors.
+	factor float64
+	paused bool
+	pace   chan struct{}
+}
+
+func NewScheduler(logger *logrus.Logger) *Scheduler {
+	return &Scheduler{
+		Logger:  logger,
+		results: make(map[string]Result),
+		factor:  1,
+		pace:    make(chan struct{}),
+	}
+}
+
+// SetPace stretches every interval by factor, or pauses collection altogether
+func (s *Scheduler) SetPace(factor float64, paused bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if factor < 1 {
+		factor = 1
+	}
+	if factor == s.factor && paused == s.paused {
+		return
+	}
+	s.factor = factor
+	s.paused = paused
+	close(s.pace)
+	s.pace = make(chan struct{})
+}
+
+// Pace returns the current interval factor and whether collection is paused
+func (s *Scheduler) Pace() (factor float64, paused bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.factor, s.paused
+}
+
+func (s *Scheduler) currentPace() (factor float64, paused bool, changed chan struct{}) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.factor, s.paused, s.pace
+}
+
+// Start stops the running collectors and starts the given ones. Results of collectors that keep
+// running are kept, the others are dropped.
+func (s *Scheduler) Start(ctx context.Context, collectors []Collector) {
+	s.Stop()
+
+	names := make(map[string]bool)
+	for _, collector := range collectors {
+		names[collector.Name] = true
+	}
+	s.lock.Lock()
+	for name := range s.results {
+		if !names[name] {
+			delete(s.results, name)
+		}
+	}
+	s.lock.Unlock()
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	for _, collector := range collectors {
+		s.wg.Add(1)
+		go s.run(ctx, collector)
+	}
+}
+
+// Stop stops every collector and waits for the ones in the middle of a run. The collectors are
+// closed, a collector whose run was abandoned is closed once that run finishes.
+func (s *Scheduler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+	s.cancel = nil
+}
+
+func (s *Scheduler) run(ctx context.Context, collector Collector) {
+	defer s.wg.Done()
+	var abandoned <-chan struct{}
+	defer func() {
+		if collector.Close == nil {
+			return
+		}
+		if abandoned == nil {
+			collector.Close()
+			return
+		}
+		go func() {
+			<-abandoned
+			collector.Close()
+		}()
+	}()
+
+	primed := collector.Baseline == 0
+	for ctx.Err() == nil {
+		lastRun := time.Now()
+		if !primed {
+			if primed, abandoned = s.prime(ctx, collector); abandoned != nil {
+				return
+			}
+		}
+		if primed {
+			if abandoned = s.collect(ctx, collector); abandoned != nil {
+				return
+			}
+		}
+
+		// Wait for the (scaled) interval to pass since the last run, starting over whenever the
+		// pace changes so a speed up takes effect right away
+		for waiting := true; waiting; {
+			factor, paused, changed := s.currentPace()
+			var timer *time.Timer
+			var expired <-chan time.Time
+			if !paused {
+				remaining := time.Duration(float64(collector.Interval)*factor) - time.Since(lastRun)
+				if remaining <= 0 {
+					break
+				}
+				timer = time.NewTimer(remaining)
+				expired = timer.C
+			}
+
+			select {
+			case <-ctx.Done():
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			case <-changed:
+			case <-expired:
+				waiting = false
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// prime takes the sample a collector with a Baseline starts from and waits for the baseline. A
+// failed sample is recorded as the result of the collector, which is primed again on its next run.
+func (s *Scheduler) prime(ctx context.Context, collector Collector) (primed bool, abandoned <-chan struct{}) {
+	start := time.Now()
+	_, abandoned, err := s.execute(ctx, collector)
+	if abandoned != nil {
+		return false, abandoned
+	}
+	if err != nil {
+		s.record(collector.Name, nil, err, time.Since(start))
+		return false, nil
+	}
+
+	timer := time.NewTimer(collector.Baseline)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false, nil
+	case <-timer.C:
+		return true, nil
+	}
+}
+
+// collect runs a collector and records its result. Stopping the scheduler does not wait for a
+// collector that is still running, its result is dropped when it finishes. The returned channel is
+// then closed once it finishes, it is nil when the run completed.
+func (s *Scheduler) collect(ctx context.Context, collector Collector) (abandoned <-chan struct{}) {
+	start := time.Now()
+	data, abandoned, err := s.execute(ctx, collector)
+	if abandoned != nil {
+		return abandoned
+	}
+	s.record(collector.Name, data, err, time.Since(start))
+	return nil
+}
+
+// execute runs a collector until it finishes or the context is done. In the latter case the
+// returned channel is closed once the run finishes.
+func (s *Scheduler) execute(ctx context.Context, collector Collector) (data interface{}, abandoned <-chan struct{}, err error) {
+	type outcome struct {
+		data interface{}
+		err  error
+	}
+	done := make(chan outcome, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		data, err := collector.Collect()
+		done <- outcome{data, err}
+	}()
+	select {
+	case result := <-done:
+		return result.data, nil, result.err
+	case <-ctx.Done():
+		return nil, finished, nil
+	}
+}
+
+// record stores the outcome of a run of the named collector
+func (s *Scheduler) record(name string, data interface{}, err error, duration time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result := s.results[name]
+	result.Duration = duration
+	if err != nil {
+		// Only log when the error changes so a persistently failing collector does not flood the log
+		if s.Logger != nil && (result.Err == nil || result.Err.Error() != err.Error()) {
+			s.Logger.Warnf("failed to collect the %s section: %s", name, err.Error())
+		}
+		result.Err = err
+	} else {
+		result.Data = data
+		result.Err = nil
+		result.CollectedAt = time.Now()
+	}
+	s.results[name] = result
+}
+
+// CollectOnce runs every collector once, all at the same time, waits for them to finish and closes
+// them. The collectors with a Baseline take their first sample together and share a single wait for
+// the longest baseline, a collector whose first sample fails keeps that error as its result.
+func (s *Scheduler) CollectOnce(collectors []Collector) {
+	var wg sync.WaitGroup
+	var baseline time.Duration
+	failed := make([]bool, len(collectors))
+	for i, collector := range collectors {
+		if collector.Baseline == 0 {
+			continue
+		}
+		if collector.Baseline > baseline {
+			baseline = collector.Baseline
+		}
+		wg.Add(1)
+		go func(i int, collector Collector) {
+			defer wg.Done()
+			start := time.Now()
+			if _, _, err := s.execute(context.Background(), collector); err != nil {
+				s.record(collector.Name, nil, err, time.Since(start))
+				failed[i] = true
+			}
+		}(i, collector)
+	}
+	wg.Wait()
+	time.Sleep(baseline)
+
+	for i, collector := range collectors {
+		wg.Add(1)
+		go func(collector Collector, failed bool) {
+			defer wg.Done()
+			if !failed {
+				s.collect(context.Background(), collector)
+			}
+			if collector.Close != nil {
+				collector.Close()
+			}
+		}(collector, failed[i])
+	}
+	wg.Wait()
+}
+
+// Results returns a copy of the latest results keyed by collector name
+func (s *Scheduler) Results() (results map[string]Result) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	results = make(map[string]Result, len(s.results))
+	for name, result := range s.results {
+		results[name] = result
+	}
+	return results
+}
@@ -0,0 +1,156 @@
+package lock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNotRunning is returned by Read when no instance holds the lockfile
+var ErrNotRunning = errors.New("not running")
+
+// Info is written to the lockfile so that other invocations can find and control the running instance
+type Info struct {
+	PID        int      `json:"pid"`
+	StartTime  uint64   `json:"start_time"`
+	Version    string   `json:"version"`
+	Executable string   `json:"executable"`
+	Args       []string `json:"args"`
+	// OutputFile is where the instance writes its snapshots, which its configuration may have moved
+	OutputFile string `json:"output_file"`
+}
+
+// Lock is a lockfile held with flock(2) for as long as the instance runs. The kernel drops the
+// lock when the process dies, so a lockfile nobody holds a lock on is stale and can be reclaimed.
+type Lock struct {
+	Path string
+	file *os.File
+}
+
+// Acquire takes the lockfile at path and writes info into it. When the file was left behind by an
+// instance that is gone, its previous contents are returned as stale.
+func Acquire(path string, info Info) (l *Lock, stale *Info, err error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create the lockfile \"%s\": %s", path, err.Error())
+		}
+
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err != nil {
+			previous, readErr := decode(f)
+			f.Close()
+			if errors.Is(err, unix.EWOULDBLOCK) {
+				if readErr == nil {
+					return nil, nil, fmt.Errorf("wsstats is already running with PID %d (lockfile \"%s\")", previous.PID, path)
+				}
+				return nil, nil, fmt.Errorf("wsstats is already running (lockfile \"%s\")", path)
+			}
+			return nil, nil, fmt.Errorf("failed to lock \"%s\": %s", path, err.Error())
+		}
+
+		// The previous holder may have removed the file between our open and flock, in which case
+		// we locked an orphaned inode and have to start over
+		if !samePath(f, path) {
+			f.Close()
+			continue
+		}
+
+		previous, readErr := decode(f)
+		if readErr == nil {
+			stale = &previous
+		}
+
+		jsonBytes, err := json.MarshalIndent(info, "", "    ")
+		if err == nil {
+			err = f.Truncate(0)
+		}
+		if err == nil {
+			_, err = f.WriteAt(jsonBytes, 0)
+		}
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to write the lockfile \"%s\": %s", path, err.Error())
+		}
+		return &Lock{Path: path, file: f}, stale, nil
+	}
+	return nil, nil, fmt.Errorf("failed to lock \"%s\": it keeps being replaced", path)
+}
+
+// Release removes the lockfile and drops the lock
+func (l *Lock) Release() (err error) {
+	err = os.Remove(l.Path)
+	if err != nil && !os.IsNotExist(err) {
+		err = fmt.Errorf("failed to remove the lockfile \"%s\": %s", l.Path, err.Error())
+	} else {
+		err = nil
+	}
+	l.file.Close()
+	return err
+}
+
+// Read returns the contents of the lockfile at path if an instance currently holds it
+func Read(path string) (info Info, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, fmt.Errorf("%w - the lockfile \"%s\" does not exist", ErrNotRunning, path)
+		}
+		return info, fmt.Errorf("failed to read the lockfile \"%s\"", path)
+	}
+	defer f.Close()
+
+	info, err = decode(f)
+	if err != nil {
+		return info, fmt.Errorf("the lockfile \"%s\" does not contain a valid PID", path)
+	}
+
+	// Getting a shared lock means nobody holds the exclusive one
+	err = unix.Flock(int(f.Fd()), unix.LOCK_SH|unix.LOCK_NB)
+	if err == nil {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		return info, fmt.Errorf("%w - the lockfile \"%s\" is stale, PID %d no longer holds it", ErrNotRunning, path, info.PID)
+	}
+	if !errors.Is(err, unix.EWOULDBLOCK) {
+		return info, fmt.Errorf("failed to check the lockfile \"%s\": %s", path, err.Error())
+	}
+	return info, nil
+}
+
+// ProcessAlive reports whether a process with the given PID exists
+func ProcessAlive(pid int) bool {
+	err := syscall.Kill(pid, syscall.Signal(0))
+	return err == nil || err == syscall.EPERM
+}
+
+func decode(f *os.File) (info Info, err error) {
+	jsonBytes, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<20))
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(jsonBytes, &info)
+	if err != nil {
+		return info, err
+	}
+	if info.PID <= 1 {
+		return info, fmt.Errorf("invalid PID %d", info.PID)
+	}
+	return info, nil
+}
+
+func samePath(f *os.File, path string) bool {
+	openInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(openInfo, pathInfo)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/stats"
+)
+
+// ServeMetrics serves the latest snapshot in the Prometheus text format on /metrics of the
+// configured address. It is called again on every reload and only restarts the server when the
+// address changed.
+func (w *Wezterm) ServeMetrics() (err error) {
+	address := w.Config.Metrics.Listen
+	if address == w.MetricsAddress {
+		return nil
+	}
+	if w.MetricsServer != nil {
+		w.MetricsServer.Close()
+		w.MetricsServer = nil
+	}
+	w.MetricsAddress = ""
+	if address == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics on \"%s\": %s", address, err.Error())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", w.handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			w.Logger.Warnf("failed to serve metrics: %s", err.Error())
+		}
+	}()
+	w.MetricsServer = server
+	w.MetricsAddress = address
+	return nil
+}
+
+func (w *Wezterm) handleMetrics(rw http.ResponseWriter, r *http.Request) {
+	w.MarkRead()
+
+	w.SnapshotLock.RLock()
+	output := w.LatestOutput
+	w.SnapshotLock.RUnlock()
+
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheusText(rw, output)
+}
+
+// writePrometheusText writes every number kept in the history as a wsstats_value sample named by
+// its metric label, e.g. wsstats_value{metric="cpu.total"}, followed by the metrics of the .prom
+// files of the textfile collector under their own names and labels
+func writePrometheusText(out io.Writer, output map[string]interface{}) {
+	samples := flattenSamples(output)
+	names := make([]string, 0, len(samples))
+	for name := range samples {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) > 0 {
+		fmt.Fprintln(out, "# HELP wsstats_value The numbers of the latest wsstats snapshot, named as in the history")
+		fmt.Fprintln(out, "# TYPE wsstats_value gauge")
+	}
+	for _, name := range names {
+		fmt.Fprintf(out, "wsstats_value{metric=\"%s\"} %s\n", escapeLabelValue(name), formatPrometheusValue(samples[name]))
+	}
+
+	textfiles, ok := output["textfile"].(stats.TextfileData)
+	if !ok {
+		return
+	}
+	// The samples of a metric have to follow each other, a metric can be written by several files
+	var metrics []stats.TextfileMetric
+	for _, file := range textfiles.Files {
+		metrics = append(metrics, file.Metrics...)
+	}
+	type line struct{ name, text string }
+	lines := make([]line, 0, len(metrics))
+	for _, metric := range metrics {
+		labelNames := make([]string, 0, len(metric.Labels))
+		for labelName := range metric.Labels {
+			labelNames = append(labelNames, labelName)
+		}
+		sort.Strings(labelNames)
+		labels := make([]string, 0, len(labelNames))
+		for _, labelName := range labelNames {
+			labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(metric.Labels[labelName])))
+		}
+		text := metric.Name
+		if len(labels) > 0 {
+			text += "{" + strings.Join(labels, ",") + "}"
+		}
+		lines = append(lines, line{metric.Name, text + " " + formatPrometheusValue(metric.Value)})
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].name != lines[j].name {
+			return lines[i].name < lines[j].name
+		}
+		return lines[i].text < lines[j].text
+	})
+	for _, line := range lines {
+		fmt.Fprintln(out, line.text)
+	}
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatPrometheusValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
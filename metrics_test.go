@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gdanko/wsstats/stats"
+)
+
+func TestWritePrometheusText(t *testing.T) {
+	output := map[string]interface{}{
+		"textfile": stats.TextfileData{Files: map[string]stats.TextfileFile{
+			"backup": {Metrics: []stats.TextfileMetric{
+				{Name: "backup_size_bytes", Labels: map[string]string{"target": "media"}, Value: 2048},
+				{Name: "backup_ok", Value: 1},
+			}},
+			"nas": {Metrics: []stats.TextfileMetric{
+				{Name: "backup_size_bytes", Labels: map[string]string{"target": `nas "1"`}, Value: 4096},
+			}},
+		}},
+	}
+	var out bytes.Buffer
+	writePrometheusText(&out, output)
+	want := `# HELP wsstats_value The numbers of the latest wsstats snapshot, named as in the history
+# TYPE wsstats_value gauge
+wsstats_value{metric="textfile.backup.backup_ok"} 1
+wsstats_value{metric="textfile.backup.backup_size_bytes.media"} 2048
+wsstats_value{metric="textfile.backup.stale"} 0
+wsstats_value{metric="textfile.nas.backup_size_bytes.nas_\"1\""} 4096
+wsstats_value{metric="textfile.nas.stale"} 0
+backup_ok 1
+backup_size_bytes{target="media"} 2048
+backup_size_bytes{target="nas \"1\""} 4096
+`
+	if out.String() != want {
+		t.Errorf("wrote\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	writePrometheusText(&out, nil)
+	if out.Len() != 0 {
+		t.Errorf("wrote %q without a snapshot", out.String())
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	test_runner "github.com/gdanko/wsstats/gather"
+	"github.com/gdanko/wsstats/stats"
+	"github.com/gdanko/wsstats/util"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// flattenSamples picks the numeric values worth keeping a history of out of a snapshot and
+// names them with dotted paths, e.g. cpu.total or network.wlan0.bytes_recv
+func flattenSamples(output map[string]interface{}) (samples map[string]float64) {
+	samples = make(map[string]float64)
+	for section, data := range output {
+		switch section {
+		case "battery":
+			if powerSupplyData, ok := data.(stats.PowerSupplyData); ok {
+				for _, battery := range powerSupplyData.Batteries {
+					prefix := fmt.Sprintf("battery.%s", battery.Name)
+					samples[prefix+".percent"] = battery.Percent
+					samples[prefix+".power_w"] = battery.PowerSmoothed
+				}
+			}
+		case "cgroup":
+			if cgroups, ok := data.(stats.CgroupData); ok {
+				addCgroupSamples(samples, "cgroup", cgroups.CgroupStats)
+				for name, cgroup := range cgroups.Breakdown {
+					addCgroupSamples(samples, fmt.Sprintf("cgroup.%s", name), cgroup)
+				}
+			}
+		case "containers":
+			if containers, ok := data.(stats.ContainersData); ok {
+				samples["containers.running"] = float64(containers.Running)
+				for _, container := range containers.Containers {
+					prefix := fmt.Sprintf("containers.%s", container.Name)
+					samples[prefix+".cpu_percent"] = container.CPUPercent
+					samples[prefix+".memory_usage"] = float64(container.MemoryUsage)
+					samples[prefix+".network_rx_per_sec"] = container.NetworkRxPerSec
+					samples[prefix+".network_tx_per_sec"] = container.NetworkTxPerSec
+				}
+			}
+		case "cpu":
+			if cpuPercent, ok := data.([]stats.PercentStat); ok {
+				for _, cpu := range cpuPercent {
+					prefix := "cpu"
+					if cpu.CPU != "cpu-total" {
+						prefix = fmt.Sprintf("cpu.%s", cpu.CPU)
+					}
+					samples[prefix+".total"] = util.RoundTo(100-cpu.Idle, 2)
+					samples[prefix+".user"] = cpu.User
+					samples[prefix+".system"] = cpu.System
+					samples[prefix+".iowait"] = cpu.Iowait
+					samples[prefix+".steal"] = cpu.Steal
+					if cpu.Frequency != nil {
+						samples[prefix+".frequency_mhz"] = cpu.Frequency.Average
+						samples[prefix+".core_throttles"] = float64(cpu.Frequency.CoreThrottles)
+						samples[prefix+".package_throttles"] = float64(cpu.Frequency.PackageThrottles)
+					}
+				}
+			}
+		case "disk":
+			if diskUsage, ok := data.([]stats.DiskUsageData); ok {
+				for _, disk := range diskUsage {
+					prefix := fmt.Sprintf("disk.%s", disk.MountPoint)
+					samples[prefix+".used"] = float64(disk.Used)
+					samples[prefix+".used_percent"] = disk.UsedPercent
+				}
+			}
+		case "events":
+			if events, ok := data.(stats.KernelEventsData); ok {
+				samples["events.oom_kills"] = float64(events.Counts.OOMKills)
+				samples["events.segfaults"] = float64(events.Counts.Segfaults)
+				samples["events.io_errors"] = float64(events.Counts.IOErrors)
+			}
+		case "load":
+			if loadAverages, ok := data.(*load.AvgStat); ok {
+				samples["load.load1"] = loadAverages.Load1
+				samples["load.load5"] = loadAverages.Load5
+				samples["load.load15"] = loadAverages.Load15
+			}
+		case "memory":
+			if memoryUsage, ok := data.(*mem.VirtualMemoryStat); ok {
+				samples["memory.used"] = float64(memoryUsage.Used)
+				samples["memory.available"] = float64(memoryUsage.Available)
+				samples["memory.used_percent"] = util.RoundTo(memoryUsage.UsedPercent, 2)
+			}
+		case "memory_detail":
+			if memory, ok := data.(stats.MemoryDetailData); ok {
+				samples["memory_detail.cached"] = float64(memory.Cached)
+				samples["memory_detail.dirty"] = float64(memory.Dirty)
+				samples["memory_detail.swap_used"] = float64(memory.SwapUsed)
+				samples["memory_detail.swap_in_per_sec"] = memory.SwapInPerSec
+				samples["memory_detail.swap_out_per_sec"] = memory.SwapOutPerSec
+				samples["memory_detail.major_faults_per_sec"] = memory.MajorFaultsPerSec
+			}
+		case "network":
+			if networkThroughput, ok := data.([]test_runner.NetworkInterfaceData); ok {
+				for _, iface := range networkThroughput {
+					prefix := fmt.Sprintf("network.%s", iface.Interface)
+					samples[prefix+".bytes_recv"] = iface.BytesRecv
+					samples[prefix+".bytes_sent"] = iface.BytesSent
+					samples[prefix+".bytes_recv_per_sec"] = iface.BytesRecvPerSec
+					samples[prefix+".bytes_sent_per_sec"] = iface.BytesSentPerSec
+					if iface.Wifi != nil {
+						samples[prefix+".wifi.quality"] = iface.Wifi.Quality
+						samples[prefix+".wifi.signal"] = iface.Wifi.Signal
+						samples[prefix+".wifi.bitrate"] = iface.Wifi.Bitrate
+					}
+				}
+			}
+		case "textfile":
+			if textfiles, ok := data.(stats.TextfileData); ok {
+				for name, file := range textfiles.Files {
+					prefix := fmt.Sprintf("textfile.%s", name)
+					samples[prefix+".stale"] = 0
+					if file.Stale {
+						samples[prefix+".stale"] = 1
+					}
+					flattenValue(samples, prefix, file.Data)
+					for _, metric := range file.Metrics {
+						samples[prefix+"."+metric.SampleName()] = metric.Value
+					}
+				}
+			}
+		case "watches":
+			if watches, ok := data.([]stats.WatchData); ok {
+				for _, watch := range watches {
+					prefix := fmt.Sprintf("watches.%s", watch.Name)
+					samples[prefix+".instances"] = float64(watch.Instances)
+					samples[prefix+".cpu_percent"] = watch.CPUPercent
+					samples[prefix+".rss"] = float64(watch.RSS)
+				}
+			}
+		case "network_context":
+			if networkContext, ok := data.(stats.NetworkContextData); ok {
+				samples["network_context.vpn"] = 0
+				if networkContext.VPN.Active {
+					samples["network_context.vpn"] = 1
+				}
+			}
+		case "pressure":
+			if pressureData, ok := data.(stats.PressureData); ok {
+				for resource, pressure := range map[string]stats.PressureResource{"cpu": pressureData.CPU, "memory": pressureData.Memory, "io": pressureData.IO} {
+					prefix := fmt.Sprintf("pressure.%s", resource)
+					samples[prefix+".some_avg10"] = pressure.Some.Avg10
+					samples[prefix+".some_stall_percent"] = pressure.Some.StallPercent
+					samples[prefix+".full_avg10"] = pressure.Full.Avg10
+					samples[prefix+".full_stall_percent"] = pressure.Full.StallPercent
+				}
+			}
+		case "rapl":
+			if rapl, ok := data.(stats.RaplData); ok && rapl.Readable {
+				samples["rapl.package_w"] = rapl.Package
+				samples["rapl.core_w"] = rapl.Core
+				samples["rapl.uncore_w"] = rapl.Uncore
+				samples["rapl.dram_w"] = rapl.DRAM
+				samples["rapl.psys_w"] = rapl.Psys
+			}
+		case "sockets":
+			if sockets, ok := data.(stats.SocketsData); ok {
+				samples["sockets.tcp"] = float64(sockets.TCP)
+				samples["sockets.udp"] = float64(sockets.UDP)
+				for state, count := range sockets.TCPStates {
+					samples[fmt.Sprintf("sockets.tcp_states.%s", state)] = float64(count)
+				}
+				samples["sockets.retransmits_per_sec"] = sockets.RetransmitsPerSec
+				samples["sockets.retransmit_percent"] = sockets.RetransmitPercent
+			}
+		case "probes":
+			if probes, ok := data.([]stats.ProbeData); ok {
+				for _, probe := range probes {
+					prefix := fmt.Sprintf("probes.%s", probe.Name)
+					samples[prefix+".up"] = 0
+					if probe.Up {
+						samples[prefix+".up"] = 1
+					}
+					samples[prefix+".latency_ms"] = probe.LatencyMs
+					samples[prefix+".success_ratio"] = probe.SuccessRatio
+				}
+			}
+		case "swap":
+			if swapUsage, ok := data.(*mem.SwapMemoryStat); ok {
+				samples["swap.used"] = float64(swapUsage.Used)
+				samples["swap.used_percent"] = util.RoundTo(swapUsage.UsedPercent, 2)
+				samples["swap.sin"] = float64(swapUsage.Sin)
+				samples["swap.sout"] = float64(swapUsage.Sout)
+			}
+		case "temperature":
+			if temperatures, ok := data.(stats.TemperatureData); ok {
+				if temperatures.CPUSensor != "" {
+					samples["temperature.cpu"] = temperatures.CPU
+				}
+				for _, chip := range temperatures.Chips {
+					if len(chip.Sensors) > 0 {
+						samples[fmt.Sprintf("temperature.%s.max", chip.Name)] = chip.Max
+					}
+					for _, fan := range chip.Fans {
+						samples[fmt.Sprintf("temperature.%s.%s_rpm", chip.Name, strings.ReplaceAll(fan.Label, " ", "_"))] = float64(fan.RPM)
+					}
+				}
+			}
+		default:
+			// The sections of the exec collectors
+			if output, ok := data.(stats.CommandOutput); ok {
+				flattenValue(samples, section, output.Value)
+			}
+		}
+	}
+	return samples
+}
+
+// flattenValue adds the numbers of a decoded JSON value, naming the ones nested in objects and
+// arrays by their keys and indexes, e.g. gpu.cards.0.temperature
+func flattenValue(samples map[string]float64, prefix string, value interface{}) {
+	switch value := value.(type) {
+	case float64:
+		samples[prefix] = value
+	case bool:
+		samples[prefix] = 0
+		if value {
+			samples[prefix] = 1
+		}
+	case map[string]interface{}:
+		for key, nested := range value {
+			flattenValue(samples, prefix+"."+strings.ReplaceAll(key, " ", "_"), nested)
+		}
+	case []interface{}:
+		for i, nested := range value {
+			flattenValue(samples, fmt.Sprintf("%s.%d", prefix, i), nested)
+		}
+	}
+}
+
+func addCgroupSamples(samples map[string]float64, prefix string, cgroup stats.CgroupStats) {
+	samples[prefix+".cpu_usage"] = cgroup.CPU.Usage
+	samples[prefix+".cpu_usage_percent"] = cgroup.CPU.UsagePercent
+	samples[prefix+".cpu_throttled"] = float64(cgroup.CPU.Throttled)
+	samples[prefix+".memory_current"] = float64(cgroup.Memory.Current)
+	samples[prefix+".memory_used_percent"] = cgroup.Memory.UsedPercent
+	samples[prefix+".memory_oom_kill"] = float64(cgroup.Memory.Events.OOMKill)
+	samples[prefix+".io_read_bytes_per_sec"] = cgroup.IO.ReadBytesPerSec
+	samples[prefix+".io_write_bytes_per_sec"] = cgroup.IO.WriteBytesPerSec
+	samples[prefix+".pids_current"] = float64(cgroup.Pids.Current)
+}
@@ -0,0 +1,190 @@
+package selector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Step is one component of a path. Key selects a field of an object, Index an element of an
+// array and FilterKey/FilterValue the first array element whose field equals the value.
+type Step struct {
+	Key         string
+	Index       int
+	HasIndex    bool
+	FilterKey   string
+	FilterValue string
+}
+
+// Parse splits an expression such as network[interface=wlan0].bytes_recv or cpu[0].user into steps
+func Parse(expression string) (steps []Step, err error) {
+	expression = strings.TrimSpace(expression)
+	if expression == "" {
+		return steps, fmt.Errorf("the path is empty")
+	}
+
+	for i := 0; i < len(expression); {
+		switch expression[i] {
+		case '.':
+			if i == 0 || i == len(expression)-1 || expression[i+1] == '.' {
+				return steps, fmt.Errorf("unexpected \".\" at position %d of \"%s\"", i+1, expression)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(expression[i:], ']')
+			if end == -1 {
+				return steps, fmt.Errorf("unterminated \"[\" at position %d of \"%s\"", i+1, expression)
+			}
+			step, err := parseBracket(expression[i+1 : i+end])
+			if err != nil {
+				return steps, err
+			}
+			steps = append(steps, step)
+			i += end + 1
+		default:
+			end := strings.IndexAny(expression[i:], ".[")
+			if end == -1 {
+				end = len(expression) - i
+			}
+			steps = append(steps, Step{Key: expression[i : i+end]})
+			i += end
+		}
+	}
+	return steps, nil
+}
+
+func parseBracket(contents string) (step Step, err error) {
+	key, value, found := strings.Cut(contents, "=")
+	if !found {
+		index, err := strconv.Atoi(strings.TrimSpace(contents))
+		if err != nil {
+			return step, fmt.Errorf("invalid index \"[%s]\"", contents)
+		}
+		return Step{Index: index, HasIndex: true}, nil
+	}
+
+	key = strings.TrimSpace(key)
+	value = strings.Trim(strings.TrimSpace(value), `"'`)
+	if key == "" {
+		return step, fmt.Errorf("invalid filter \"[%s]\"", contents)
+	}
+	return Step{FilterKey: key, FilterValue: value}, nil
+}
+
+// Select walks decoded JSON (maps, slices and scalars as produced by encoding/json) along the path
+func Select(data interface{}, expression string) (value interface{}, err error) {
+	steps, err := Parse(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	value = data
+	walked := ""
+	for _, step := range steps {
+		switch {
+		case step.Key != "":
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", describe(walked))
+			}
+			value, ok = lookup(object, step.Key)
+			if !ok {
+				return nil, fmt.Errorf("%s has no field \"%s\"", describe(walked), step.Key)
+			}
+			walked = strings.TrimPrefix(walked+"."+step.Key, ".")
+		case step.HasIndex:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", describe(walked))
+			}
+			index := step.Index
+			if index < 0 {
+				index += len(array)
+			}
+			if index < 0 || index >= len(array) {
+				return nil, fmt.Errorf("index %d is out of range for %s", step.Index, describe(walked))
+			}
+			value = array[index]
+			walked = fmt.Sprintf("%s[%d]", walked, step.Index)
+		default:
+			array, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", describe(walked))
+			}
+			value, ok = filter(array, step.FilterKey, step.FilterValue)
+			if !ok {
+				return nil, fmt.Errorf("no element of %s has %s=%s", describe(walked), step.FilterKey, step.FilterValue)
+			}
+			walked = fmt.Sprintf("%s[%s=%s]", walked, step.FilterKey, step.FilterValue)
+		}
+	}
+	return value, nil
+}
+
+func filter(array []interface{}, key, value string) (element interface{}, found bool) {
+	for _, element = range array {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		field, ok := lookup(object, key)
+		if ok && Format(field) == value {
+			return element, true
+		}
+	}
+	return nil, false
+}
+
+// lookup finds a key in an object. Sections that come straight from gopsutil use camelCase
+// keys, they can be selected with the snake_case names of the rest of the snapshot as well, e.g.
+// memory.used_percent for memory.usedPercent.
+func lookup(object map[string]interface{}, key string) (value interface{}, found bool) {
+	if value, found = object[key]; found {
+		return value, true
+	}
+	for name, value := range object {
+		if snakeCase(name) == key {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func snakeCase(name string) string {
+	var builder strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 && !unicode.IsUpper(rune(name[i-1])) {
+				builder.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+func describe(walked string) string {
+	if walked == "" {
+		return "the snapshot"
+	}
+	return fmt.Sprintf("\"%s\"", walked)
+}
+
+// Format renders a scalar the way a shell script expects it: strings without quotes and numbers
+// without exponents. Objects and arrays are not handled here and come back as Go syntax.
+func Format(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// ServeSocket answers every connection to the control socket with the latest snapshot, so
+// clients can reuse the running instance instead of collecting the data themselves
+func (w *Wezterm) ServeSocket() (err error) {
+	// Anything left at this path belongs to a previous run, we hold the lockfile
+	err = util.DeleteFile(w.Socket)
+	if err != nil {
+		return err
+	}
+
+	w.Listener, err = net.Listen("unix", w.Socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on the socket \"%s\": %s", w.Socket, err.Error())
+	}
+	err = os.Chmod(w.Socket, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to set the permissions of the socket \"%s\": %s", w.Socket, err.Error())
+	}
+
+	go func() {
+		for {
+			conn, err := w.Listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				w.Logger.Warnf("failed to accept a socket connection: %s", err.Error())
+				continue
+			}
+			go w.handleSocketConnection(conn)
+		}
+	}()
+	return nil
+}
+
+func (w *Wezterm) handleSocketConnection(conn net.Conn) {
+	defer conn.Close()
+	w.MarkRead()
+
+	w.SnapshotLock.RLock()
+	snapshot := w.LatestSnapshot
+	w.SnapshotLock.RUnlock()
+
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write(snapshot)
+	if err != nil {
+		w.Logger.Warnf("failed to write the snapshot to the socket: %s", err.Error())
+	}
+}
+
+// ReadSnapshot fetches the latest snapshot of the running instance from its socket, falling back
+// to its output file when the socket cannot be reached. The output file outlives an instance that
+// was killed, so it is only read while the instance holds its lockfile.
+func (w *Wezterm) ReadSnapshot() (snapshot []byte, err error) {
+	info, err := w.RunningInstance()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", w.Socket, 2*time.Second)
+	if err == nil {
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		snapshot, err = io.ReadAll(conn)
+		if err == nil && len(snapshot) > 0 {
+			return snapshot, nil
+		}
+	}
+
+	outputFile := w.OutputFile
+	if info.OutputFile != "" {
+		outputFile = info.OutputFile
+	}
+	snapshot, err = os.ReadFile(outputFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no snapshot found at \"%s\", PID %d has not written one yet", outputFile, info.PID)
+		}
+		return nil, fmt.Errorf("failed to read \"%s\": %s", outputFile, err.Error())
+	}
+	return snapshot, nil
+}
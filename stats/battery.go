@@ -0,0 +1,192 @@
+package stats
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// How quickly the smoothed power draw follows the instantaneous one, in seconds
+var batterySmoothingPeriod = 60.0
+
+type BatteryData struct {
+	Name             string  `json:"name"`
+	Status           string  `json:"status"`
+	Percent          float64 `json:"percent"`
+	EnergyNow        float64 `json:"energy_now_wh"`
+	EnergyFull       float64 `json:"energy_full_wh"`
+	EnergyFullDesign float64 `json:"energy_full_design_wh"`
+	PowerNow         float64 `json:"power_now_w"`
+	PowerSmoothed    float64 `json:"power_smoothed_w"`
+	TimeToEmpty      uint64  `json:"time_to_empty"`
+	TimeToFull       uint64  `json:"time_to_full"`
+	CycleCount       uint64  `json:"cycle_count"`
+	HealthPercent    float64 `json:"health_percent"`
+	Technology       string  `json:"technology"`
+	Manufacturer     string  `json:"manufacturer"`
+	ModelName        string  `json:"model_name"`
+}
+
+type AdapterData struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Online bool   `json:"online"`
+}
+
+type PowerSupplyData struct {
+	OnBattery bool          `json:"on_battery"`
+	Batteries []BatteryData `json:"batteries"`
+	Adapters  []AdapterData `json:"adapters"`
+}
+
+type batteryState struct {
+	status    string
+	energy    float64
+	smoothed  float64
+	updatedAt time.Time
+}
+
+// PowerSupplies reads /sys/class/power_supply and keeps a smoothed power draw per battery
+// between calls to Collect, which the time to empty and to full are estimated from
+type PowerSupplies struct {
+	state map[string]*batteryState
+}
+
+func (p *PowerSupplies) Collect() (powerSupplyData PowerSupplyData, err error) {
+	if p.state == nil {
+		p.state = make(map[string]*batteryState)
+	}
+	powerSupplyData = PowerSupplyData{
+		Batteries: []BatteryData{},
+		Adapters:  []AdapterData{},
+	}
+
+	entries, err := os.ReadDir(powerSupplyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return powerSupplyData, nil
+		}
+		return powerSupplyData, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		supplyType := readPowerSupplyAttribute(name, "type")
+		switch supplyType {
+		case "Battery":
+			if readPowerSupplyAttribute(name, "scope") == "Device" {
+				continue
+			}
+			powerSupplyData.Batteries = append(powerSupplyData.Batteries, p.battery(name))
+		case "Mains", "USB", "USB_C", "USB_PD":
+			powerSupplyData.Adapters = append(powerSupplyData.Adapters, AdapterData{
+				Name:   name,
+				Type:   strings.ToLower(supplyType),
+				Online: readPowerSupplyAttribute(name, "online") == "1",
+			})
+		}
+	}
+
+	powerSupplyData.OnBattery = len(powerSupplyData.Batteries) > 0
+	for _, adapter := range powerSupplyData.Adapters {
+		if adapter.Online {
+			powerSupplyData.OnBattery = false
+		}
+	}
+	return powerSupplyData, nil
+}
+
+func (p *PowerSupplies) battery(name string) (battery BatteryData) {
+	battery = BatteryData{
+		Name:         name,
+		Status:       strings.ToLower(readPowerSupplyAttribute(name, "status")),
+		Technology:   readPowerSupplyAttribute(name, "technology"),
+		Manufacturer: readPowerSupplyAttribute(name, "manufacturer"),
+		ModelName:    readPowerSupplyAttribute(name, "model_name"),
+		CycleCount:   uint64(readPowerSupplyNumber(name, "cycle_count")),
+	}
+
+	// Some batteries report energy (µWh) and power (µW), others charge (µAh) and current (µA)
+	voltage := readPowerSupplyNumber(name, "voltage_now") / 1e6
+	if voltage == 0 {
+		voltage = readPowerSupplyNumber(name, "voltage_min_design") / 1e6
+	}
+	energy := func(prefix string) float64 {
+		if value := readPowerSupplyNumber(name, "energy_"+prefix); value > 0 {
+			return value / 1e6
+		}
+		return readPowerSupplyNumber(name, "charge_"+prefix) / 1e6 * voltage
+	}
+	battery.EnergyNow = util.RoundTo(energy("now"), 2)
+	battery.EnergyFull = util.RoundTo(energy("full"), 2)
+	battery.EnergyFullDesign = util.RoundTo(energy("full_design"), 2)
+
+	power := readPowerSupplyNumber(name, "power_now") / 1e6
+	if power == 0 {
+		power = readPowerSupplyNumber(name, "current_now") / 1e6 * voltage
+	}
+	// A few drivers report the draw as negative while discharging
+	power = math.Abs(power)
+	battery.PowerNow = util.RoundTo(power, 2)
+
+	if capacity := readPowerSupplyAttribute(name, "capacity"); capacity != "" {
+		battery.Percent, _ = strconv.ParseFloat(capacity, 64)
+	} else if battery.EnergyFull > 0 {
+		battery.Percent = util.RoundTo(battery.EnergyNow/battery.EnergyFull*100, 2)
+	}
+	if battery.EnergyFullDesign > 0 {
+		battery.HealthPercent = util.RoundTo(battery.EnergyFull/battery.EnergyFullDesign*100, 2)
+	}
+
+	battery.PowerSmoothed = util.RoundTo(p.smooth(name, battery.Status, energy("now"), power), 2)
+	if battery.PowerSmoothed > 0 {
+		switch battery.Status {
+		case "discharging":
+			battery.TimeToEmpty = uint64(battery.EnergyNow / battery.PowerSmoothed * 3600)
+		case "charging":
+			battery.TimeToFull = uint64(math.Max(0, battery.EnergyFull-battery.EnergyNow) / battery.PowerSmoothed * 3600)
+		}
+	}
+	return battery
+}
+
+// smooth returns an exponentially weighted average of the power draw. Without a power reading the
+// draw is derived from the change in energy. The average starts over when the status changes.
+func (p *PowerSupplies) smooth(name, status string, energy, power float64) float64 {
+	now := time.Now()
+	state, ok := p.state[name]
+	if !ok || state.status != status {
+		state = &batteryState{status: status, energy: energy, smoothed: power, updatedAt: now}
+		p.state[name] = state
+		return power
+	}
+
+	elapsed := now.Sub(state.updatedAt).Seconds()
+	if elapsed <= 0 {
+		return state.smoothed
+	}
+	if power == 0 {
+		power = math.Abs(energy-state.energy) / (elapsed / 3600)
+	}
+	if state.smoothed == 0 {
+		state.smoothed = power
+	} else {
+		alpha := 1 - math.Exp(-elapsed/batterySmoothingPeriod)
+		state.smoothed += alpha * (power - state.smoothed)
+	}
+	state.energy = energy
+	state.updatedAt = now
+	return state.smoothed
+}
+
+func readPowerSupplyNumber(supply, attribute string) float64 {
+	value, err := strconv.ParseFloat(readPowerSupplyAttribute(supply, attribute), 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,342 @@
+package stats
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+type CgroupCPU struct {
+	// Limit is the number of CPUs the tightest cpu.max of the cgroup and its ancestors allows, 0
+	// when unlimited
+	Limit float64 `json:"limit"`
+	// Usage is the number of CPUs used since the previous sample, UsagePercent relates it to the
+	// limit or else to the CPUs of the machine
+	Usage         float64 `json:"usage"`
+	UsagePercent  float64 `json:"usage_percent"`
+	Throttled     uint64  `json:"throttled"`
+	ThrottledUsec uint64  `json:"throttled_usec"`
+}
+
+type CgroupMemoryEvents struct {
+	Low     uint64 `json:"low"`
+	High    uint64 `json:"high"`
+	Max     uint64 `json:"max"`
+	OOM     uint64 `json:"oom"`
+	OOMKill uint64 `json:"oom_kill"`
+}
+
+type CgroupMemory struct {
+	Current uint64 `json:"current"`
+	// Max and High are the tightest limits of the cgroup and its ancestors, 0 when unlimited
+	Max         uint64             `json:"max"`
+	High        uint64             `json:"high"`
+	UsedPercent float64            `json:"used_percent"`
+	SwapCurrent uint64             `json:"swap_current"`
+	Events      CgroupMemoryEvents `json:"events"`
+}
+
+type CgroupIODevice struct {
+	Device     string `json:"device"`
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	ReadIOs    uint64 `json:"read_ios"`
+	WriteIOs   uint64 `json:"write_ios"`
+}
+
+type CgroupIO struct {
+	ReadBytesPerSec  float64          `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64          `json:"write_bytes_per_sec"`
+	Devices          []CgroupIODevice `json:"devices"`
+}
+
+type CgroupPids struct {
+	Current uint64 `json:"current"`
+	// Max is the tightest limit of the cgroup and its ancestors, 0 when unlimited
+	Max uint64 `json:"max"`
+}
+
+type CgroupStats struct {
+	Path   string       `json:"path"`
+	CPU    CgroupCPU    `json:"cpu"`
+	Memory CgroupMemory `json:"memory"`
+	IO     CgroupIO     `json:"io"`
+	Pids   CgroupPids   `json:"pids"`
+}
+
+type CgroupData struct {
+	CgroupStats
+	Breakdown map[string]CgroupStats `json:"breakdown,omitempty"`
+}
+
+type cgroupCounters struct {
+	usageUsec  uint64
+	readBytes  uint64
+	writeBytes uint64
+	at         time.Time
+}
+
+// Cgroups reports the limits and usage of the cgroup v2 wsstats runs in, which is what a dev
+// container or a systemd slice is actually allowed rather than the resources of the host. The
+// limits are the effective ones, systemd often sets them on a slice such as user-1000.slice rather
+// than on the cgroup of the process. Breakdown lists further cgroups to report, as paths relative
+// to the cgroup root such as user.slice, or as unit names such as postgresql.service which are
+// looked up in the tree.
+type Cgroups struct {
+	Breakdown []string
+	previous  map[string]cgroupCounters
+	// resolved caches the paths of the breakdown entries, they are only looked up again once gone
+	resolved map[string]string
+}
+
+func (c *Cgroups) Collect() (cgroups CgroupData, err error) {
+	root, err := cgroup2Root()
+	if err != nil {
+		return cgroups, err
+	}
+	self, err := ownCgroup()
+	if err != nil {
+		return cgroups, err
+	}
+
+	current := make(map[string]cgroupCounters)
+	cgroups.CgroupStats, err = c.stats(root, self, current)
+	if err != nil {
+		return cgroups, err
+	}
+
+	if len(c.Breakdown) > 0 {
+		cgroups.Breakdown = make(map[string]CgroupStats)
+	}
+	if c.resolved == nil {
+		c.resolved = make(map[string]string)
+	}
+	for _, name := range c.Breakdown {
+		path, ok := c.resolved[name]
+		if _, err := os.Stat(filepath.Join(root, path)); !ok || err != nil {
+			path, err = findCgroup(root, name)
+			if err != nil {
+				return cgroups, err
+			}
+			c.resolved[name] = path
+		}
+		cgroups.Breakdown[name], err = c.stats(root, path, current)
+		if err != nil {
+			return cgroups, err
+		}
+	}
+	c.previous = current
+	return cgroups, nil
+}
+
+func (c *Cgroups) stats(root, path string, current map[string]cgroupCounters) (stats CgroupStats, err error) {
+	dir := filepath.Join(root, path)
+	if _, err = os.Stat(dir); err != nil {
+		return stats, fmt.Errorf("failed to read the cgroup \"%s\": %s", path, err.Error())
+	}
+	stats.Path = path
+	now := time.Now()
+
+	cpuStat, _ := readKeyValueFile(filepath.Join(dir, "cpu.stat"))
+	stats.CPU.Throttled = cpuStat["nr_throttled"]
+	stats.CPU.ThrottledUsec = cpuStat["throttled_usec"]
+	stats.CPU.Limit = util.RoundTo(effectiveLimit(root, path, readCPULimit), 2)
+
+	stats.Memory.Current = readCgroupLimit(filepath.Join(dir, "memory.current"))
+	stats.Memory.Max = uint64(effectiveLimit(root, path, cgroupLimitReader("memory.max")))
+	stats.Memory.High = uint64(effectiveLimit(root, path, cgroupLimitReader("memory.high")))
+	stats.Memory.SwapCurrent = readCgroupLimit(filepath.Join(dir, "memory.swap.current"))
+	if stats.Memory.Max > 0 {
+		stats.Memory.UsedPercent = util.RoundTo(float64(stats.Memory.Current)/float64(stats.Memory.Max)*100, 2)
+	}
+	events, _ := readKeyValueFile(filepath.Join(dir, "memory.events"))
+	stats.Memory.Events = CgroupMemoryEvents{
+		Low:     events["low"],
+		High:    events["high"],
+		Max:     events["max"],
+		OOM:     events["oom"],
+		OOMKill: events["oom_kill"],
+	}
+
+	stats.IO.Devices = readCgroupIO(filepath.Join(dir, "io.stat"))
+	counters := cgroupCounters{usageUsec: cpuStat["usage_usec"], at: now}
+	for _, device := range stats.IO.Devices {
+		counters.readBytes += device.ReadBytes
+		counters.writeBytes += device.WriteBytes
+	}
+	current[path] = counters
+
+	if previous, ok := c.previous[path]; ok {
+		elapsed := now.Sub(previous.at).Seconds()
+		if elapsed > 0 && counters.usageUsec >= previous.usageUsec {
+			stats.CPU.Usage = util.RoundTo(float64(counters.usageUsec-previous.usageUsec)/1e6/elapsed, 2)
+			limit := stats.CPU.Limit
+			if limit == 0 {
+				limit = float64(runtime.NumCPU())
+			}
+			stats.CPU.UsagePercent = util.RoundTo(stats.CPU.Usage/limit*100, 2)
+		}
+		if elapsed > 0 && counters.readBytes >= previous.readBytes && counters.writeBytes >= previous.writeBytes {
+			stats.IO.ReadBytesPerSec = util.RoundTo(float64(counters.readBytes-previous.readBytes)/elapsed, 2)
+			stats.IO.WriteBytesPerSec = util.RoundTo(float64(counters.writeBytes-previous.writeBytes)/elapsed, 2)
+		}
+	}
+
+	stats.Pids.Current = readCgroupLimit(filepath.Join(dir, "pids.current"))
+	stats.Pids.Max = uint64(effectiveLimit(root, path, cgroupLimitReader("pids.max")))
+	return stats, nil
+}
+
+// effectiveLimit returns the tightest limit read from the cgroup at path and its ancestors, 0
+// when none of them sets one
+func effectiveLimit(root, path string, read func(dir string) float64) (limit float64) {
+	for {
+		if value := read(filepath.Join(root, path)); value > 0 && (limit == 0 || value < limit) {
+			limit = value
+		}
+		if path == "/" || path == "" || path == "." {
+			return limit
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+func cgroupLimitReader(file string) func(dir string) float64 {
+	return func(dir string) float64 {
+		return float64(readCgroupLimit(filepath.Join(dir, file)))
+	}
+}
+
+// readCPULimit reads cpu.max, "quota period" in microseconds, as a number of CPUs
+func readCPULimit(dir string) float64 {
+	fields := strings.Fields(readSysfsString(filepath.Join(dir, "cpu.max")))
+	if len(fields) != 2 || fields[0] == "max" {
+		return 0
+	}
+	quota, _ := strconv.ParseFloat(fields[0], 64)
+	period, _ := strconv.ParseFloat(fields[1], 64)
+	if period <= 0 {
+		return 0
+	}
+	return quota / period
+}
+
+// cgroup2Root returns where the cgroup v2 hierarchy is mounted, which is below unified/ on
+// systems still using the hybrid layout
+func cgroup2Root() (root string, err error) {
+	for _, root = range []string{cgroupPath, filepath.Join(cgroupPath, "unified")} {
+		if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 is not mounted at %s", cgroupPath)
+}
+
+// ownCgroup returns the cgroup v2 path of wsstats from the 0:: line of /proc/self/cgroup
+func ownCgroup() (path string, err error) {
+	f, err := os.Open(filepath.Join(procPath, "self", "cgroup"))
+	if err != nil {
+		return path, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path, found := strings.CutPrefix(scanner.Text(), "0::"); found {
+			return path, nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return path, err
+	}
+	return path, fmt.Errorf("wsstats is not in a cgroup v2")
+}
+
+// findCgroup resolves a breakdown entry. Paths are used as they are while names without a
+// slash, such as postgresql.service, are searched for in the whole tree.
+func findCgroup(root, name string) (path string, err error) {
+	if strings.Contains(name, "/") {
+		return "/" + strings.Trim(name, "/"), nil
+	}
+	if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+		return "/" + name, nil
+	}
+	errFound := errors.New("found")
+	err = filepath.WalkDir(root, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() && entry.Name() == name {
+			path = "/" + strings.TrimPrefix(dir, root+"/")
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return path, nil
+	}
+	return path, fmt.Errorf("no cgroup \"%s\"", name)
+}
+
+// readCgroupLimit reads a single number, where "max" or a missing file reads as 0
+func readCgroupLimit(path string) uint64 {
+	value, _ := strconv.ParseUint(readSysfsString(path), 10, 64)
+	return value
+}
+
+// readCgroupIO parses io.stat lines such as "8:0 rbytes=1024 wbytes=0 rios=1 wios=0 ..."
+func readCgroupIO(path string) (devices []CgroupIODevice) {
+	devices = []CgroupIODevice{}
+	for _, line := range strings.Split(readSysfsString(path), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		device := CgroupIODevice{Device: blockDeviceName(fields[0])}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			number, _ := strconv.ParseUint(value, 10, 64)
+			switch key {
+			case "rbytes":
+				device.ReadBytes = number
+			case "wbytes":
+				device.WriteBytes = number
+			case "rios":
+				device.ReadIOs = number
+			case "wios":
+				device.WriteIOs = number
+			}
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return devices
+}
+
+// blockDeviceName turns a major:minor number into a name such as sda, keeping the number when
+// the device is unknown
+func blockDeviceName(number string) string {
+	f, err := os.Open(filepath.Join("/sys/dev/block", number, "uevent"))
+	if err != nil {
+		return number
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if name, found := strings.CutPrefix(scanner.Text(), "DEVNAME="); found {
+			return name
+		}
+	}
+	return number
+}
@@ -0,0 +1,267 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+type ContainerData struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Image  string `json:"image"`
+	State  string `json:"state"`
+	Status string `json:"status"`
+	// Health is healthy, unhealthy or starting, empty for containers without a health check
+	Health          string  `json:"health"`
+	CPUPercent      float64 `json:"cpu_percent"`
+	MemoryUsage     uint64  `json:"memory_usage"`
+	MemoryLimit     uint64  `json:"memory_limit"`
+	MemoryPercent   float64 `json:"memory_percent"`
+	NetworkRx       uint64  `json:"network_rx"`
+	NetworkTx       uint64  `json:"network_tx"`
+	NetworkRxPerSec float64 `json:"network_rx_per_sec"`
+	NetworkTxPerSec float64 `json:"network_tx_per_sec"`
+}
+
+type ContainersData struct {
+	Socket     string          `json:"socket"`
+	Running    int             `json:"running"`
+	Containers []ContainerData `json:"containers"`
+}
+
+type containerSummary struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Image  string   `json:"Image"`
+	State  string   `json:"State"`
+	Status string   `json:"Status"`
+}
+
+type containerStats struct {
+	CPUStats struct {
+		CPUUsage struct {
+			TotalUsage  uint64   `json:"total_usage"`
+			PercpuUsage []uint64 `json:"percpu_usage"`
+		} `json:"cpu_usage"`
+		SystemCPUUsage uint64 `json:"system_cpu_usage"`
+		OnlineCPUs     uint64 `json:"online_cpus"`
+	} `json:"cpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+	Networks map[string]struct {
+		RxBytes uint64 `json:"rx_bytes"`
+		TxBytes uint64 `json:"tx_bytes"`
+	} `json:"networks"`
+}
+
+type containerCounters struct {
+	cpu       uint64
+	system    uint64
+	rx        uint64
+	tx        uint64
+	collected time.Time
+}
+
+// Containers lists the running containers of Docker, or of Podman through its Docker compatible
+// API, with their resource usage. CPU usage and network rates are computed from the difference
+// with the previous call to Collect, so the first call reports them as 0.
+type Containers struct {
+	// Socket is the path of the API socket, found among the usual locations when empty
+	Socket     string
+	Timeout    time.Duration
+	previous   map[string]containerCounters
+	httpClient *http.Client
+	httpSocket string
+}
+
+func (c *Containers) Collect() (containers ContainersData, err error) {
+	socket := c.Socket
+	if socket == "" {
+		socket, err = findContainerSocket()
+		if err != nil {
+			return containers, err
+		}
+	}
+	containers.Socket = socket
+	client := c.client(socket)
+
+	var summaries []containerSummary
+	if err = containerGet(client, "/containers/json", &summaries); err != nil {
+		return containers, fmt.Errorf("failed to list the containers at %s: %s", socket, err.Error())
+	}
+
+	containers.Containers = make([]ContainerData, len(summaries))
+	stats := make([]containerStats, len(summaries))
+	errs := make([]error, len(summaries))
+	var wg sync.WaitGroup
+	for i, summary := range summaries {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			errs[i] = containerGet(client, "/containers/"+id+"/stats?stream=false&one-shot=true", &stats[i])
+		}(i, summary.ID)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	current := make(map[string]containerCounters)
+	for i, summary := range summaries {
+		container := ContainerData{
+			ID:     summary.ID,
+			Image:  summary.Image,
+			State:  summary.State,
+			Status: summary.Status,
+			Health: containerHealth(summary.Status),
+		}
+		if len(summary.Names) > 0 {
+			container.Name = strings.TrimPrefix(summary.Names[0], "/")
+		}
+		if summary.State == "running" {
+			containers.Running++
+		}
+		// A container that stops in the meantime has no stats, it is still listed
+		if errs[i] == nil {
+			current[summary.ID] = c.usage(&container, stats[i], now)
+		}
+		containers.Containers[i] = container
+	}
+	c.previous = current
+
+	sort.Slice(containers.Containers, func(i, j int) bool {
+		return containers.Containers[i].Name < containers.Containers[j].Name
+	})
+	return containers, nil
+}
+
+// usage fills in the resource usage of a container the same way docker stats computes it
+func (c *Containers) usage(container *ContainerData, stats containerStats, now time.Time) (counters containerCounters) {
+	counters = containerCounters{
+		cpu:       stats.CPUStats.CPUUsage.TotalUsage,
+		system:    stats.CPUStats.SystemCPUUsage,
+		collected: now,
+	}
+	for _, network := range stats.Networks {
+		counters.rx += network.RxBytes
+		counters.tx += network.TxBytes
+	}
+	container.NetworkRx = counters.rx
+	container.NetworkTx = counters.tx
+
+	// The page cache is reclaimable and not counted as used, inactive_file on cgroup v2 and
+	// total_inactive_file or cache on cgroup v1
+	container.MemoryUsage = stats.MemoryStats.Usage
+	for _, key := range []string{"inactive_file", "total_inactive_file", "cache"} {
+		if cache, ok := stats.MemoryStats.Stats[key]; ok {
+			if cache < container.MemoryUsage {
+				container.MemoryUsage -= cache
+			}
+			break
+		}
+	}
+	container.MemoryLimit = stats.MemoryStats.Limit
+	if container.MemoryLimit > 0 {
+		container.MemoryPercent = util.RoundTo(float64(container.MemoryUsage)/float64(container.MemoryLimit)*100, 2)
+	}
+
+	previous, ok := c.previous[container.ID]
+	if !ok {
+		return counters
+	}
+	onlineCPUs := stats.CPUStats.OnlineCPUs
+	if onlineCPUs == 0 {
+		onlineCPUs = uint64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if counters.cpu >= previous.cpu && counters.system > previous.system {
+		cpuDelta := float64(counters.cpu - previous.cpu)
+		systemDelta := float64(counters.system - previous.system)
+		container.CPUPercent = util.RoundTo(cpuDelta/systemDelta*float64(onlineCPUs)*100, 2)
+	}
+	if elapsed := now.Sub(previous.collected).Seconds(); elapsed > 0 && counters.rx >= previous.rx && counters.tx >= previous.tx {
+		container.NetworkRxPerSec = util.RoundTo(float64(counters.rx-previous.rx)/elapsed, 2)
+		container.NetworkTxPerSec = util.RoundTo(float64(counters.tx-previous.tx)/elapsed, 2)
+	}
+	return counters
+}
+
+// client returns the HTTP client of the socket, reused between calls to keep the connections alive
+func (c *Containers) client(socket string) *http.Client {
+	if c.httpClient != nil && c.httpSocket == socket {
+		return c.httpClient
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	c.httpSocket = socket
+	c.httpClient = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	return c.httpClient
+}
+
+func containerGet(client *http.Client, path string, target interface{}) (err error) {
+	// The host is ignored as every request goes to the socket
+	response, err := client.Get("http://localhost" + path)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+// containerHealth extracts the health from a status such as "Up 2 hours (healthy)"
+func containerHealth(status string) string {
+	switch {
+	case strings.Contains(status, "(healthy)"):
+		return "healthy"
+	case strings.Contains(status, "(unhealthy)"):
+		return "unhealthy"
+	case strings.Contains(status, "(health: starting)"), strings.Contains(status, "(starting)"):
+		return "starting"
+	}
+	return ""
+}
+
+// findContainerSocket looks for the socket of DOCKER_HOST, then of Docker, then of rootless
+// Podman and finally of Podman
+func findContainerSocket() (socket string, err error) {
+	var candidates []string
+	if host, found := strings.CutPrefix(os.Getenv("DOCKER_HOST"), "unix://"); found {
+		candidates = append(candidates, host)
+	}
+	candidates = append(candidates, "/var/run/docker.sock")
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		candidates = append(candidates, filepath.Join(runtimeDir, "podman", "podman.sock"))
+	}
+	candidates = append(candidates, "/run/podman/podman.sock")
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return candidate, nil
+		}
+	}
+	return socket, fmt.Errorf("no Docker or Podman socket found, set containers.socket in the configuration")
+}
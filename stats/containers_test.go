@@ -0,0 +1,156 @@
+package stats
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeDockerAPI serves the endpoints of the Docker API that Containers uses on a unix socket. Every
+// stats call of the web container reports more CPU time and traffic than the previous one.
+type fakeDockerAPI struct {
+	sync.Mutex
+	calls int
+}
+
+func (f *fakeDockerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch r.URL.Path {
+	case "/containers/json":
+		json.NewEncoder(w).Encode([]map[string]interface{}{
+			{"Id": "aaa", "Names": []string{"/web"}, "Image": "nginx", "State": "running", "Status": "Up 2 hours (healthy)"},
+			{"Id": "bbb", "Names": []string{"/worker"}, "Image": "busybox", "State": "exited", "Status": "Exited (0) 5 seconds ago"},
+		})
+	case "/containers/aaa/stats":
+		if r.URL.Query().Get("stream") != "false" || r.URL.Query().Get("one-shot") != "true" {
+			http.Error(w, "streaming is not expected", http.StatusBadRequest)
+			return
+		}
+		f.calls++
+		// Between two calls the container used 2 of 10 seconds of system time on 4 CPUs
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cpu_stats": map[string]interface{}{
+				"cpu_usage":        map[string]interface{}{"total_usage": 1e9 + f.calls*2e9},
+				"system_cpu_usage": 100e9 + f.calls*10e9,
+				"online_cpus":      4,
+			},
+			"memory_stats": map[string]interface{}{
+				"usage": 300 << 20,
+				"limit": 1 << 30,
+				"stats": map[string]interface{}{"inactive_file": 100 << 20},
+			},
+			"networks": map[string]interface{}{
+				"eth0": map[string]interface{}{"rx_bytes": f.calls * 1000, "tx_bytes": f.calls * 500},
+			},
+		})
+	case "/containers/bbb/stats":
+		http.Error(w, "container is not running", http.StatusConflict)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func startFakeDockerAPI(t *testing.T) (socket string) {
+	socket = filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(&fakeDockerAPI{})
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+	return socket
+}
+
+func TestContainersCollect(t *testing.T) {
+	socket := startFakeDockerAPI(t)
+	containers := &Containers{Socket: socket}
+
+	first, err := containers.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Socket != socket || first.Running != 1 || len(first.Containers) != 2 {
+		t.Fatalf("unexpected containers %+v", first)
+	}
+	web, worker := first.Containers[0], first.Containers[1]
+	if web.Name != "web" || web.Image != "nginx" || web.Health != "healthy" {
+		t.Errorf("unexpected web container %+v", web)
+	}
+	// Without a previous sample there is no CPU usage or rate yet
+	if web.CPUPercent != 0 || web.NetworkRxPerSec != 0 {
+		t.Errorf("first collection reported CPU %v and rx %v per second", web.CPUPercent, web.NetworkRxPerSec)
+	}
+	if web.MemoryUsage != 200<<20 {
+		t.Errorf("memory usage is %d, want the usage minus inactive_file %d", web.MemoryUsage, 200<<20)
+	}
+	if web.MemoryLimit != 1<<30 || web.MemoryPercent != 19.53 {
+		t.Errorf("memory limit is %d and percent %v", web.MemoryLimit, web.MemoryPercent)
+	}
+	if web.NetworkRx != 1000 || web.NetworkTx != 500 {
+		t.Errorf("network is %d received and %d sent", web.NetworkRx, web.NetworkTx)
+	}
+	// The stats of the exited container failed, it is still listed without usage
+	if worker.Name != "worker" || worker.State != "exited" || worker.Health != "" || worker.MemoryUsage != 0 {
+		t.Errorf("unexpected worker container %+v", worker)
+	}
+
+	second, err := containers.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	web = second.Containers[0]
+	if web.CPUPercent != 80 {
+		t.Errorf("CPU percent is %v, want 2s/10s on 4 CPUs = 80", web.CPUPercent)
+	}
+	if web.NetworkRx != 2000 || web.NetworkRxPerSec <= 0 || web.NetworkTxPerSec <= 0 {
+		t.Errorf("network is %d received at %v and %v per second", web.NetworkRx, web.NetworkRxPerSec, web.NetworkTxPerSec)
+	}
+}
+
+func TestContainersCollectWithoutAPI(t *testing.T) {
+	containers := &Containers{Socket: filepath.Join(t.TempDir(), "missing.sock")}
+	if _, err := containers.Collect(); err == nil {
+		t.Error("expected an error for a socket nothing listens on")
+	}
+}
+
+func TestContainerHealth(t *testing.T) {
+	for status, health := range map[string]string{
+		"Up 2 hours (healthy)":            "healthy",
+		"Up 3 minutes (unhealthy)":        "unhealthy",
+		"Up 4 seconds (health: starting)": "starting",
+		"Up 4 seconds (starting)":         "starting",
+		"Up 10 days":                      "",
+		"Exited (1) 2 minutes ago":        "",
+	} {
+		if got := containerHealth(status); got != health {
+			t.Errorf("containerHealth(%q) = %q, want %q", status, got, health)
+		}
+	}
+}
+
+func TestFindContainerSocket(t *testing.T) {
+	socket := startFakeDockerAPI(t)
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+	found, err := findContainerSocket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found != socket {
+		t.Errorf("found %s, want the socket of DOCKER_HOST %s", found, socket)
+	}
+
+	// A DOCKER_HOST that is not a socket is skipped
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "missing.sock"))
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	if found, err = findContainerSocket(); err == nil && found == socket {
+		t.Errorf("found %s although DOCKER_HOST points elsewhere", found)
+	}
+}
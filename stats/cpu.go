@@ -19,6 +19,8 @@ type PercentStat struct {
 	Steal     float64 `json:"steal"`
 	Guest     float64 `json:"guest"`
 	GuestNice float64 `json:"guestNice"`
+	// Frequency is only set on the cpu-total entry
+	Frequency *CpuFrequencyData `json:"frequency,omitempty"`
 }
 
 func cpuTimeDeltas(t1, t2 cpu.TimesStat) cpu.TimesStat {
@@ -44,7 +46,12 @@ func cpuTotalTime(timesDelta cpu.TimesStat) (total float64) {
 func calculate(t1, t2 cpu.TimesStat) (percentStat PercentStat) {
 	timesDelta := cpuTimeDeltas(t1, t2)
 	allDelta := cpuTotalTime(timesDelta)
-	scale := 100.0 / math.Max(1, allDelta)
+	// The deltas are in seconds and a short interval on a single CPU adds up to less than one, only
+	// an interval without any tick is left at zero
+	scale := 0.0
+	if allDelta > 0 {
+		scale = 100.0 / allDelta
+	}
 
 	// fieldPercent := value * scale
 	// fieldPercent = math.Min(math.Max(0.0, fieldPercent), 100.0)
@@ -65,13 +72,12 @@ func calculate(t1, t2 cpu.TimesStat) (percentStat PercentStat) {
 	}
 }
 
-func GetCpuPercent(perCpu bool) ([]PercentStat, error) {
+func GetCpuPercent(perCpu bool, interval time.Duration) ([]PercentStat, error) {
 	var (
 		lastPerCpuTimes  []cpu.TimesStat
 		lastPerCpuTimes2 []cpu.TimesStat
 		blocking         bool = false
 		err              error
-		interval         float64 = 1.0
 		lastCpuTimes     []cpu.TimesStat
 		lastCpuTimes2    []cpu.TimesStat
 		output           []PercentStat
@@ -90,7 +96,7 @@ func GetCpuPercent(perCpu bool) ([]PercentStat, error) {
 	}
 	lastPerCpuTimes2 = lastPerCpuTimes
 
-	if interval > 0.0 {
+	if interval > 0 {
 		blocking = true
 	}
 
@@ -100,7 +106,7 @@ func GetCpuPercent(perCpu bool) ([]PercentStat, error) {
 			if err != nil {
 				return nil, err
 			}
-			time.Sleep(time.Duration(interval) * time.Second)
+			time.Sleep(interval)
 		} else {
 			t1 = lastCpuTimes2
 			if t1 == nil {
@@ -122,7 +128,7 @@ func GetCpuPercent(perCpu bool) ([]PercentStat, error) {
 			if err != nil {
 				return nil, err
 			}
-			time.Sleep(time.Duration(interval) * time.Second)
+			time.Sleep(interval)
 		} else {
 			t1 = lastPerCpuTimes2
 			if t1 == nil {
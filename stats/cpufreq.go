@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+var cpuPath = "/sys/devices/system/cpu"
+
+// CoreFrequency holds the frequencies of one core in MHz. The throttle counters count how often
+// the core or its package went over the temperature limit since boot.
+type CoreFrequency struct {
+	CPU                         string  `json:"cpu"`
+	Current                     float64 `json:"current"`
+	Min                         float64 `json:"min"`
+	Max                         float64 `json:"max"`
+	Governor                    string  `json:"governor"`
+	EnergyPerformancePreference string  `json:"energy_performance_preference"`
+	CoreThrottleCount           uint64  `json:"core_throttle_count"`
+	PackageThrottleCount        uint64  `json:"package_throttle_count"`
+}
+
+type CpuFrequencyData struct {
+	// Average is the mean current frequency of the cores in MHz
+	Average float64 `json:"average"`
+	// CoreThrottles adds up the core counters, PackageThrottles counts every package once
+	CoreThrottles    uint64          `json:"core_throttles"`
+	PackageThrottles uint64          `json:"package_throttles"`
+	Cores            []CoreFrequency `json:"cores"`
+}
+
+// GetCpuFrequency reads cpufreq and thermal_throttle for every core. It returns nil on machines
+// that expose neither, such as most virtual machines.
+func GetCpuFrequency() (frequency *CpuFrequencyData, err error) {
+	cores, err := filepath.Glob(filepath.Join(cpuPath, "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	sortByIndex(cores, "cpu")
+
+	data := &CpuFrequencyData{Cores: []CoreFrequency{}}
+	packages := make(map[string]uint64)
+	var total float64
+	var withFrequency int
+	for _, core := range cores {
+		cpufreq := filepath.Join(core, "cpufreq")
+		throttle := filepath.Join(core, "thermal_throttle")
+		if !exists(cpufreq) && !exists(throttle) {
+			continue
+		}
+
+		coreFrequency := CoreFrequency{
+			CPU:                         filepath.Base(core),
+			Current:                     readKilohertz(filepath.Join(cpufreq, "scaling_cur_freq")),
+			Min:                         readKilohertz(filepath.Join(cpufreq, "scaling_min_freq")),
+			Max:                         readKilohertz(filepath.Join(cpufreq, "scaling_max_freq")),
+			Governor:                    readSysfsString(filepath.Join(cpufreq, "scaling_governor")),
+			EnergyPerformancePreference: readSysfsString(filepath.Join(cpufreq, "energy_performance_preference")),
+		}
+		coreFrequency.CoreThrottleCount, _ = strconv.ParseUint(readSysfsString(filepath.Join(throttle, "core_throttle_count")), 10, 64)
+		coreFrequency.PackageThrottleCount, _ = strconv.ParseUint(readSysfsString(filepath.Join(throttle, "package_throttle_count")), 10, 64)
+
+		data.CoreThrottles += coreFrequency.CoreThrottleCount
+		packageID := readSysfsString(filepath.Join(core, "topology", "physical_package_id"))
+		packages[packageID] = coreFrequency.PackageThrottleCount
+		if coreFrequency.Current > 0 {
+			total += coreFrequency.Current
+			withFrequency++
+		}
+		data.Cores = append(data.Cores, coreFrequency)
+	}
+	if len(data.Cores) == 0 {
+		return nil, nil
+	}
+
+	for _, count := range packages {
+		data.PackageThrottles += count
+	}
+	if withFrequency > 0 {
+		data.Average = util.RoundTo(total/float64(withFrequency), 0)
+	}
+	return data, nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func readKilohertz(path string) float64 {
+	value, err := strconv.ParseUint(readSysfsString(path), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return util.RoundTo(float64(value)/1000, 0)
+}
@@ -0,0 +1,227 @@
+package stats
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var kmsgPath = "/dev/kmsg"
+
+var (
+	// Out of memory: Killed process 1234 (cc1plus) total-vm:..., also logged for memory cgroups
+	oomKillPattern = regexp.MustCompile(`Killed process (\d+) \(([^)]*)\)`)
+	// cc1plus[1234]: segfault at 0 ip ... sp ... error 4 in ...
+	segfaultPattern = regexp.MustCompile(`^(\S+)\[(\d+)\]: segfault at`)
+	// I/O error, dev sda, sector 2048 op 0x0:(READ) ... or Buffer I/O error on dev sda1, logical block 0
+	ioErrorPattern = regexp.MustCompile(`I/O error,? (?:on )?dev ([^ ,]+)`)
+)
+
+type KernelEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	PID     int       `json:"pid,omitempty"`
+	Process string    `json:"process,omitempty"`
+	Device  string    `json:"device,omitempty"`
+	Message string    `json:"message"`
+}
+
+type KernelEventCounts struct {
+	OOMKills  uint64 `json:"oom_kills"`
+	Segfaults uint64 `json:"segfaults"`
+	IOErrors  uint64 `json:"io_errors"`
+}
+
+type KernelEventsData struct {
+	// Source is kmsg, or vmstat when the kernel log cannot be read and only OOM kills are counted
+	Source string            `json:"source"`
+	Counts KernelEventCounts `json:"counts"`
+	Recent []KernelEvent     `json:"recent"`
+}
+
+// KernelEvents follows the kernel log for OOM kills, segfaults and I/O errors logged since it
+// started and keeps the Recent most recent ones. Reading /dev/kmsg may need CAP_SYSLOG, without it
+// only the oom_kill counter of /proc/vmstat is available and its events have no victim.
+type KernelEvents struct {
+	Recent int
+	// kmsg is kept open between calls until Close
+	kmsg       *os.File
+	counts     KernelEventCounts
+	recent     []KernelEvent
+	oomKills   uint64
+	useVmstat  bool
+	vmstatSeen bool
+}
+
+func (k *KernelEvents) Collect() (events KernelEventsData, err error) {
+	events.Source = "kmsg"
+	if !k.useVmstat {
+		err = k.readKmsg()
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) || errors.Is(err, syscall.ENOENT) {
+			k.useVmstat = true
+		} else if err != nil {
+			return events, err
+		}
+	}
+	if k.useVmstat {
+		events.Source = "vmstat"
+		if err = k.readVmstat(); err != nil {
+			return events, err
+		}
+	}
+
+	events.Counts = k.counts
+	events.Recent = make([]KernelEvent, len(k.recent))
+	copy(events.Recent, k.recent)
+	return events, nil
+}
+
+// readKmsg reads the records added to the kernel log since the previous call. /dev/kmsg is opened
+// once and positioned after the last record, so that the events logged before wsstats started are
+// not reported, and every read of it returns one record.
+func (k *KernelEvents) readKmsg() (err error) {
+	if k.kmsg == nil {
+		kmsg, err := os.OpenFile(kmsgPath, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			return err
+		}
+		if _, err = kmsg.Seek(0, io.SeekEnd); err != nil {
+			kmsg.Close()
+			return fmt.Errorf("failed to seek to the end of %s: %s", kmsgPath, err.Error())
+		}
+		k.kmsg = kmsg
+	}
+	bootTime, err := readBootTime()
+	if err != nil {
+		return err
+	}
+
+	buffer := make([]byte, 8192)
+	for {
+		n, err := readNonBlocking(k.kmsg, buffer)
+		if err == syscall.EPIPE {
+			// Records were overwritten before they could be read
+			continue
+		}
+		if err == syscall.EAGAIN {
+			break
+		}
+		if err != nil {
+			// Open it again on the next call rather than reading from a broken descriptor
+			k.Close()
+			return fmt.Errorf("failed to read %s: %s", kmsgPath, err.Error())
+		}
+		if n <= 0 {
+			break
+		}
+		_, usec, message, ok := parseKmsgRecord(string(buffer[:n]))
+		if !ok {
+			continue
+		}
+		if event, ok := parseKernelEvent(message); ok {
+			event.Time = time.Unix(int64(bootTime), 0).Add(time.Duration(usec) * time.Microsecond)
+			k.add(event, 1)
+		}
+	}
+	return nil
+}
+
+// Close closes /dev/kmsg, the next call to Collect opens it again
+func (k *KernelEvents) Close() {
+	if k.kmsg != nil {
+		k.kmsg.Close()
+		k.kmsg = nil
+	}
+}
+
+// readVmstat turns increases of the oom_kill counter into events without a victim. The first read
+// only records where the counter stands, like the kernel log the kills before wsstats started are
+// not counted.
+func (k *KernelEvents) readVmstat() (err error) {
+	vmstat, err := readKeyValueFile(filepath.Join(procPath, "vmstat"))
+	if err != nil {
+		return err
+	}
+	oomKills, ok := vmstat["oom_kill"]
+	if !ok {
+		return fmt.Errorf("%s cannot be read and %s has no oom_kill counter", kmsgPath, filepath.Join(procPath, "vmstat"))
+	}
+	if k.vmstatSeen && oomKills > k.oomKills {
+		k.add(KernelEvent{
+			Time:    time.Now(),
+			Type:    "oom_kill",
+			Message: fmt.Sprintf("%d processes killed by the OOM killer", oomKills-k.oomKills),
+		}, oomKills-k.oomKills)
+	}
+	k.oomKills = oomKills
+	k.vmstatSeen = true
+	return nil
+}
+
+// add counts an event as count occurrences and keeps it among the recent ones
+func (k *KernelEvents) add(event KernelEvent, count uint64) {
+	switch event.Type {
+	case "oom_kill":
+		k.counts.OOMKills += count
+	case "segfault":
+		k.counts.Segfaults += count
+	case "io_error":
+		k.counts.IOErrors += count
+	}
+	k.recent = append(k.recent, event)
+	if k.Recent > 0 && len(k.recent) > k.Recent {
+		k.recent = k.recent[len(k.recent)-k.Recent:]
+	}
+}
+
+// parseKmsgRecord splits a record of the form "priority,seq,usec,flags;message" followed by
+// continuation lines
+func parseKmsgRecord(record string) (seq int64, usec uint64, message string, ok bool) {
+	header, rest, found := strings.Cut(record, ";")
+	if !found {
+		return seq, usec, message, false
+	}
+	fields := strings.Split(header, ",")
+	if len(fields) < 3 {
+		return seq, usec, message, false
+	}
+	seq, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return seq, usec, message, false
+	}
+	usec, err = strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return seq, usec, message, false
+	}
+	message, _, _ = strings.Cut(rest, "\n")
+	return seq, usec, message, true
+}
+
+func parseKernelEvent(message string) (event KernelEvent, ok bool) {
+	event.Message = message
+	if match := oomKillPattern.FindStringSubmatch(message); match != nil {
+		event.Type = "oom_kill"
+		event.PID, _ = strconv.Atoi(match[1])
+		event.Process = match[2]
+		return event, true
+	}
+	if match := segfaultPattern.FindStringSubmatch(message); match != nil {
+		event.Type = "segfault"
+		event.Process = match[1]
+		event.PID, _ = strconv.Atoi(match[2])
+		return event, true
+	}
+	if match := ioErrorPattern.FindStringSubmatch(message); match != nil {
+		event.Type = "io_error"
+		event.Device = match[1]
+		return event, true
+	}
+	return event, false
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelEventsVmstat(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { procPath = path }(procPath)
+	procPath = dir
+	defer func(path string) { kmsgPath = path }(kmsgPath)
+	kmsgPath = filepath.Join(dir, "missing-kmsg")
+
+	setOOMKills := func(count int) {
+		if err := os.WriteFile(filepath.Join(dir, "vmstat"), []byte(fmt.Sprintf("pgfault 100\noom_kill %d\n", count)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := &KernelEvents{Recent: 20}
+	for i, step := range []struct {
+		oomKills int
+		counted  uint64
+		recent   int
+	}{
+		// The kills before wsstats started are not counted
+		{7, 0, 0},
+		{7, 0, 0},
+		{10, 3, 1},
+		{11, 4, 2},
+	} {
+		setOOMKills(step.oomKills)
+		data, err := events.Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Source != "vmstat" || data.Counts.OOMKills != step.counted || len(data.Recent) != step.recent {
+			t.Errorf("read %d: source %s, %d OOM kills and %d events, want %d and %d", i, data.Source, data.Counts.OOMKills, len(data.Recent), step.counted, step.recent)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// runningCommands holds the process group of every command that is running, so they can be
+// killed when wsstats exits rather than left behind
+var runningCommands = struct {
+	sync.Mutex
+	pgids map[int]bool
+}{pgids: make(map[int]bool)}
+
+// KillCommands kills the commands of the exec collectors that are still running
+func KillCommands() {
+	runningCommands.Lock()
+	defer runningCommands.Unlock()
+	for pgid := range runningCommands.pgids {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+	}
+}
+
+// CommandOutput is the parsed output of a command, written to the snapshot as it is
+type CommandOutput struct {
+	Value interface{}
+}
+
+func (c CommandOutput) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Value)
+}
+
+// Command runs Args and parses its standard output as JSON, or as key=value lines when Format is
+// "keyvalue". A command that exits with a non-zero status, runs longer than Timeout or prints
+// output that does not parse fails the collection.
+type Command struct {
+	Args    []string
+	Format  string
+	Timeout time.Duration
+}
+
+func (c *Command) Collect() (output CommandOutput, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	// Kill the whole process group on timeout, a shell would otherwise leave its children running
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = time.Second
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Start()
+	if err == nil {
+		pgid := cmd.Process.Pid
+		runningCommands.Lock()
+		runningCommands.pgids[pgid] = true
+		runningCommands.Unlock()
+		err = cmd.Wait()
+		runningCommands.Lock()
+		delete(runningCommands.pgids, pgid)
+		runningCommands.Unlock()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("\"%s\" timed out after %s", c.Args[0], c.Timeout)
+	}
+	if err != nil {
+		var exitError *exec.ExitError
+		message := err.Error()
+		if errors.As(err, &exitError) {
+			message = fmt.Sprintf("exited with status %d", exitError.ExitCode())
+		}
+		if line, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); line != "" {
+			message += ": " + line
+		}
+		return output, fmt.Errorf("\"%s\" failed: %s", c.Args[0], message)
+	}
+
+	if c.Format == "keyvalue" {
+		output.Value, err = parseKeyValueOutput(stdout.Bytes())
+	} else {
+		err = json.Unmarshal(stdout.Bytes(), &output.Value)
+	}
+	if err != nil {
+		return output, fmt.Errorf("failed to parse the output of \"%s\": %s", c.Args[0], err.Error())
+	}
+	return output, nil
+}
+
+// parseKeyValueOutput parses key=value lines, skipping blank lines and # comments. Numbers are
+// kept as numbers, everything else, including nan and inf, as strings.
+func parseKeyValueOutput(output []byte) (values map[string]interface{}, err error) {
+	values = make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return values, fmt.Errorf("line %d is not key=value", line)
+		}
+		value = strings.TrimSpace(value)
+		// NaN and infinite values can not be written as JSON numbers
+		if number, err := strconv.ParseFloat(value, 64); err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+			values[key] = number
+		} else {
+			values[key] = value
+		}
+	}
+	return values, scanner.Err()
+}
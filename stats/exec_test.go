@@ -0,0 +1,38 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyValueOutput(t *testing.T) {
+	values, err := parseKeyValueOutput([]byte(`# updates
+pending=12
+ security = 3
+temp=nan
+peak=+Inf
+low=-inf
+channel=stable
+
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"pending":  12.0,
+		"security": 3.0,
+		"temp":     "nan",
+		"peak":     "+Inf",
+		"low":      "-inf",
+		"channel":  "stable",
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("parsed %#v, want %#v", values, want)
+	}
+
+	for _, text := range []string{"pending\n", "=12\n"} {
+		if _, err := parseKeyValueOutput([]byte(text)); err == nil {
+			t.Errorf("expected an error for %q", text)
+		}
+	}
+}
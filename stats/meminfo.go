@@ -0,0 +1,165 @@
+package stats
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+var sysBlockPath = "/sys/block"
+
+type HugePagesData struct {
+	Total    uint64 `json:"total"`
+	Free     uint64 `json:"free"`
+	Reserved uint64 `json:"reserved"`
+	Size     uint64 `json:"size"`
+}
+
+// CompressionData describes compressed swap, OrigData bytes stored in ComprData bytes
+type CompressionData struct {
+	OrigData  uint64  `json:"orig_data"`
+	ComprData uint64  `json:"compr_data"`
+	Ratio     float64 `json:"ratio"`
+}
+
+type MemoryDetailData struct {
+	Total             uint64          `json:"total"`
+	Used              uint64          `json:"used"`
+	Available         uint64          `json:"available"`
+	UsedPercent       float64         `json:"used_percent"`
+	Cached            uint64          `json:"cached"`
+	Buffers           uint64          `json:"buffers"`
+	Shmem             uint64          `json:"shmem"`
+	SlabReclaimable   uint64          `json:"slab_reclaimable"`
+	Dirty             uint64          `json:"dirty"`
+	Writeback         uint64          `json:"writeback"`
+	SwapTotal         uint64          `json:"swap_total"`
+	SwapUsed          uint64          `json:"swap_used"`
+	HugePages         HugePagesData   `json:"hugepages"`
+	Zram              CompressionData `json:"zram"`
+	Zswap             CompressionData `json:"zswap"`
+	SwapInPerSec      float64         `json:"swap_in_per_sec"`
+	SwapOutPerSec     float64         `json:"swap_out_per_sec"`
+	MajorFaultsPerSec float64         `json:"major_faults_per_sec"`
+}
+
+// MemoryDetail reads /proc/meminfo and computes the swap and major fault rates from the
+// /proc/vmstat counters between calls to Collect. Swap rates are in bytes per second.
+type MemoryDetail struct {
+	previous   map[string]uint64
+	previousAt time.Time
+}
+
+func (m *MemoryDetail) Collect() (memory MemoryDetailData, err error) {
+	meminfo, err := readKeyValueFile(filepath.Join(procPath, "meminfo"))
+	if err != nil {
+		return memory, err
+	}
+	// /proc/meminfo reports kB except for the page counts
+	kb := func(key string) uint64 { return meminfo[key] * 1024 }
+
+	memory.Total = kb("MemTotal")
+	memory.Available = kb("MemAvailable")
+	memory.Used = memory.Total - memory.Available
+	if memory.Total > 0 {
+		memory.UsedPercent = util.RoundTo(float64(memory.Used)/float64(memory.Total)*100, 2)
+	}
+	memory.Cached = kb("Cached")
+	memory.Buffers = kb("Buffers")
+	memory.Shmem = kb("Shmem")
+	memory.SlabReclaimable = kb("SReclaimable")
+	memory.Dirty = kb("Dirty")
+	memory.Writeback = kb("Writeback")
+	memory.SwapTotal = kb("SwapTotal")
+	memory.SwapUsed = memory.SwapTotal - kb("SwapFree")
+	memory.HugePages = HugePagesData{
+		Total:    meminfo["HugePages_Total"],
+		Free:     meminfo["HugePages_Free"],
+		Reserved: meminfo["HugePages_Rsvd"],
+		Size:     kb("Hugepagesize"),
+	}
+	memory.Zswap = compression(kb("Zswapped"), kb("Zswap"))
+	memory.Zram = readZram()
+
+	vmstat, err := readKeyValueFile(filepath.Join(procPath, "vmstat"))
+	if err != nil {
+		return memory, err
+	}
+	now := time.Now()
+	if m.previous != nil {
+		elapsed := now.Sub(m.previousAt).Seconds()
+		rate := func(key string) float64 {
+			if elapsed <= 0 || vmstat[key] < m.previous[key] {
+				return 0
+			}
+			return util.RoundTo(float64(vmstat[key]-m.previous[key])/elapsed, 2)
+		}
+		pageSize := float64(os.Getpagesize())
+		memory.SwapInPerSec = util.RoundTo(rate("pswpin")*pageSize, 2)
+		memory.SwapOutPerSec = util.RoundTo(rate("pswpout")*pageSize, 2)
+		memory.MajorFaultsPerSec = rate("pgmajfault")
+	}
+	m.previous = vmstat
+	m.previousAt = now
+	return memory, nil
+}
+
+func compression(origData, comprData uint64) (data CompressionData) {
+	data = CompressionData{OrigData: origData, ComprData: comprData}
+	if comprData > 0 {
+		data.Ratio = util.RoundTo(float64(origData)/float64(comprData), 2)
+	}
+	return data
+}
+
+// readZram adds up the mm_stat of every zram device, whose first two fields are the original
+// and the compressed size in bytes
+func readZram() (data CompressionData) {
+	devices, _ := filepath.Glob(filepath.Join(sysBlockPath, "zram*"))
+	var origData, comprData uint64
+	for _, device := range devices {
+		contents, err := os.ReadFile(filepath.Join(device, "mm_stat"))
+		if err != nil {
+			continue
+		}
+		fields := strings.Fields(string(contents))
+		if len(fields) < 2 {
+			continue
+		}
+		orig, _ := strconv.ParseUint(fields[0], 10, 64)
+		compr, _ := strconv.ParseUint(fields[1], 10, 64)
+		origData += orig
+		comprData += compr
+	}
+	return compression(origData, comprData)
+}
+
+// readKeyValueFile parses files made of "key value" or "key: value [unit]" lines such as
+// /proc/meminfo and /proc/vmstat
+func readKeyValueFile(filename string) (values map[string]uint64, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return values, err
+	}
+	defer f.Close()
+
+	values = make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,7 @@
+package stats
+
+import "fmt"
+
+func readNl80211(ifindex int) (info nl80211Info, err error) {
+	return info, fmt.Errorf("nl80211 is only available on Linux")
+}
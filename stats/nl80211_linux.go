@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sizeofGenlmsghdr = 4
+
+// readNl80211 asks nl80211 over generic netlink for the SSID and frequency of an interface and the
+// signal and transmit bitrate of the station it is associated with
+func readNl80211(ifindex int) (info nl80211Info, err error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_GENERIC)
+	if err != nil {
+		return info, err
+	}
+	defer unix.Close(fd)
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return info, err
+	}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		return info, err
+	}
+
+	replies, err := genlRequest(fd, unix.GENL_ID_CTRL, unix.CTRL_CMD_GETFAMILY, 0,
+		netlinkAttribute(unix.CTRL_ATTR_FAMILY_NAME, []byte("nl80211\x00")))
+	if err != nil {
+		return info, fmt.Errorf("nl80211 is not available: %s", err.Error())
+	}
+	var family uint16
+	for _, reply := range replies {
+		if value, ok := parseNetlinkAttributes(reply)[unix.CTRL_ATTR_FAMILY_ID]; ok && len(value) >= 2 {
+			family = binary.NativeEndian.Uint16(value)
+		}
+	}
+	if family == 0 {
+		return info, fmt.Errorf("nl80211 is not available")
+	}
+
+	index := make([]byte, 4)
+	binary.NativeEndian.PutUint32(index, uint32(ifindex))
+	replies, err = genlRequest(fd, family, unix.NL80211_CMD_GET_INTERFACE, 0, netlinkAttribute(unix.NL80211_ATTR_IFINDEX, index))
+	if err != nil {
+		return info, err
+	}
+	for _, reply := range replies {
+		attributes := parseNetlinkAttributes(reply)
+		if ssid, ok := attributes[unix.NL80211_ATTR_SSID]; ok {
+			info.ssid = string(ssid)
+		}
+		if frequency, ok := attributes[unix.NL80211_ATTR_WIPHY_FREQ]; ok && len(frequency) >= 4 {
+			info.frequency = binary.NativeEndian.Uint32(frequency)
+		}
+	}
+
+	replies, err = genlRequest(fd, family, unix.NL80211_CMD_GET_STATION, unix.NLM_F_DUMP, netlinkAttribute(unix.NL80211_ATTR_IFINDEX, index))
+	if err != nil {
+		return info, err
+	}
+	for _, reply := range replies {
+		stationInfo, ok := parseNetlinkAttributes(reply)[unix.NL80211_ATTR_STA_INFO]
+		if !ok {
+			continue
+		}
+		station := parseNetlinkAttributes(stationInfo)
+		if signal, ok := station[unix.NL80211_STA_INFO_SIGNAL]; ok && len(signal) >= 1 {
+			info.signal = int(int8(signal[0]))
+		}
+		if txBitrate, ok := station[unix.NL80211_STA_INFO_TX_BITRATE]; ok {
+			rate := parseNetlinkAttributes(txBitrate)
+			// Both are in units of 100 kbit/s, the 16 bit one overflows above 6.5 Gbit/s
+			if bitrate, ok := rate[unix.NL80211_RATE_INFO_BITRATE32]; ok && len(bitrate) >= 4 {
+				info.bitrate = float64(binary.NativeEndian.Uint32(bitrate)) / 10
+			} else if bitrate, ok := rate[unix.NL80211_RATE_INFO_BITRATE]; ok && len(bitrate) >= 2 {
+				info.bitrate = float64(binary.NativeEndian.Uint16(bitrate)) / 10
+			}
+		}
+		break
+	}
+	return info, nil
+}
+
+// genlRequest sends a generic netlink request and returns the payload of the replies after their
+// generic netlink header
+func genlRequest(fd int, family uint16, command uint8, flags uint16, attributes []byte) (replies [][]byte, err error) {
+	length := unix.SizeofNlMsghdr + sizeofGenlmsghdr + len(attributes)
+	request := make([]byte, length)
+	binary.NativeEndian.PutUint32(request[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(request[4:6], family)
+	binary.NativeEndian.PutUint16(request[6:8], unix.NLM_F_REQUEST|flags)
+	binary.NativeEndian.PutUint32(request[8:12], 1)
+	request[unix.SizeofNlMsghdr] = command
+	request[unix.SizeofNlMsghdr+1] = 1
+	copy(request[unix.SizeofNlMsghdr+sizeofGenlmsghdr:], attributes)
+	if err = unix.Sendto(fd, request, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return replies, err
+	}
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, _, err := unix.Recvfrom(fd, buffer, 0)
+		if err != nil {
+			return replies, err
+		}
+		messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+		if err != nil {
+			return replies, err
+		}
+		for _, message := range messages {
+			switch message.Header.Type {
+			case unix.NLMSG_DONE:
+				return replies, nil
+			case unix.NLMSG_ERROR:
+				if len(message.Data) >= 4 {
+					if errno := int32(binary.NativeEndian.Uint32(message.Data[0:4])); errno != 0 {
+						return replies, syscall.Errno(-errno)
+					}
+				}
+				return replies, nil
+			}
+			if len(message.Data) >= sizeofGenlmsghdr {
+				replies = append(replies, message.Data[sizeofGenlmsghdr:])
+			}
+			if message.Header.Flags&unix.NLM_F_MULTI == 0 {
+				return replies, nil
+			}
+		}
+	}
+}
+
+func netlinkAttribute(attributeType uint16, value []byte) []byte {
+	length := unix.SizeofNlAttr + len(value)
+	attribute := make([]byte, (length+unix.NLA_ALIGNTO-1) & ^(unix.NLA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(attribute[0:2], uint16(length))
+	binary.NativeEndian.PutUint16(attribute[2:4], attributeType)
+	copy(attribute[unix.SizeofNlAttr:], value)
+	return attribute
+}
+
+// parseNetlinkAttributes indexes a list of attributes by type, stripping the nested flag
+func parseNetlinkAttributes(data []byte) (attributes map[uint16][]byte) {
+	attributes = make(map[uint16][]byte)
+	for len(data) >= unix.SizeofNlAttr {
+		length := int(binary.NativeEndian.Uint16(data[0:2]))
+		attributeType := binary.NativeEndian.Uint16(data[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if length < unix.SizeofNlAttr || length > len(data) {
+			break
+		}
+		attributes[attributeType] = data[unix.SizeofNlAttr:length]
+		aligned := (length + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+		if aligned > len(data) {
+			break
+		}
+		data = data[aligned:]
+	}
+	return attributes
+}
@@ -0,0 +1,26 @@
+package stats
+
+import (
+	"os"
+	"syscall"
+)
+
+// readNonBlocking reads once from a file opened with O_NONBLOCK, such as /dev/kmsg or an inotify
+// descriptor. It returns EAGAIN when there is nothing to read rather than waiting for data the way
+// file.Read does for descriptors registered with the poller.
+func readNonBlocking(file *os.File, buffer []byte) (n int, err error) {
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		return n, err
+	}
+	var readErr error
+	// Returning true from the callback keeps the poller from waiting when the read would block
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buffer)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	return n, err
+}
@@ -0,0 +1,44 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var powerSupplyPath = "/sys/class/power_supply"
+
+func readPowerSupplyAttribute(supply, attribute string) string {
+	contents, err := os.ReadFile(filepath.Join(powerSupplyPath, supply, attribute))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+// OnBattery reports whether the machine has a battery and no external power supply is online
+func OnBattery() (onBattery bool, err error) {
+	entries, err := os.ReadDir(powerSupplyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	hasBattery := false
+	for _, entry := range entries {
+		switch readPowerSupplyAttribute(entry.Name(), "type") {
+		case "Battery":
+			// Peripherals such as mice report their batteries here as well
+			if readPowerSupplyAttribute(entry.Name(), "scope") != "Device" {
+				hasBattery = true
+			}
+		case "Mains", "USB", "USB_C", "USB_PD":
+			if readPowerSupplyAttribute(entry.Name(), "online") == "1" {
+				return false, nil
+			}
+		}
+	}
+	return hasBattery, nil
+}
@@ -0,0 +1,151 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+var cgroupPath = "/sys/fs/cgroup"
+
+// PressureStat is one line of a PSI file. StallPercent is the share of the time since the
+// previous sample during which tasks were stalled, computed from the total stall time.
+type PressureStat struct {
+	Avg10        float64 `json:"avg10"`
+	Avg60        float64 `json:"avg60"`
+	Avg300       float64 `json:"avg300"`
+	Total        uint64  `json:"total"`
+	StallPercent float64 `json:"stall_percent"`
+}
+
+type PressureResource struct {
+	Some PressureStat `json:"some"`
+	Full PressureStat `json:"full"`
+}
+
+type PressureGroup struct {
+	CPU    PressureResource `json:"cpu"`
+	Memory PressureResource `json:"memory"`
+	IO     PressureResource `json:"io"`
+}
+
+type PressureData struct {
+	PressureGroup
+	Cgroups map[string]PressureGroup `json:"cgroups,omitempty"`
+}
+
+// Pressure reads the Pressure Stall Information of the system and of the configured cgroups,
+// which are paths relative to the cgroup v2 mount such as user.slice
+type Pressure struct {
+	Cgroups    []string
+	previous   map[string]uint64
+	previousAt time.Time
+}
+
+func (p *Pressure) Collect() (pressureData PressureData, err error) {
+	now := time.Now()
+	elapsed := now.Sub(p.previousAt).Seconds()
+	if p.previousAt.IsZero() {
+		elapsed = 0
+	}
+	current := make(map[string]uint64)
+
+	pressureData.PressureGroup, err = p.group(filepath.Join(procPath, "pressure"), "%s", current, elapsed)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return pressureData, fmt.Errorf("pressure stall information is not available, it needs Linux 4.20 with CONFIG_PSI")
+		}
+		return pressureData, err
+	}
+
+	var root string
+	if len(p.Cgroups) > 0 {
+		pressureData.Cgroups = make(map[string]PressureGroup)
+		root, err = cgroup2Root()
+		if err != nil {
+			return pressureData, err
+		}
+	}
+	for _, cgroup := range p.Cgroups {
+		group, err := p.group(filepath.Join(root, cgroup), "%s.pressure", current, elapsed)
+		if err != nil {
+			return pressureData, fmt.Errorf("failed to read the pressure of the cgroup \"%s\": %s", cgroup, err.Error())
+		}
+		pressureData.Cgroups[cgroup] = group
+	}
+
+	p.previous = current
+	p.previousAt = now
+	return pressureData, nil
+}
+
+func (p *Pressure) group(dir, filenameFormat string, current map[string]uint64, elapsed float64) (group PressureGroup, err error) {
+	for _, resource := range []struct {
+		name   string
+		target *PressureResource
+	}{
+		{"cpu", &group.CPU},
+		{"memory", &group.Memory},
+		{"io", &group.IO},
+	} {
+		filename := filepath.Join(dir, fmt.Sprintf(filenameFormat, resource.name))
+		*resource.target, err = readPressureFile(filename)
+		if err != nil {
+			return group, err
+		}
+		for kind, stat := range map[string]*PressureStat{"some": &resource.target.Some, "full": &resource.target.Full} {
+			key := filename + ":" + kind
+			current[key] = stat.Total
+			if previous, ok := p.previous[key]; ok && elapsed > 0 && stat.Total >= previous {
+				// The totals are in microseconds
+				stat.StallPercent = util.RoundTo(float64(stat.Total-previous)/(elapsed*1e6)*100, 2)
+			}
+		}
+	}
+	return group, nil
+}
+
+func readPressureFile(filename string) (resource PressureResource, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return resource, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var stat *PressureStat
+		switch fields[0] {
+		case "some":
+			stat = &resource.Some
+		case "full":
+			stat = &resource.Full
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				stat.Avg10, _ = strconv.ParseFloat(value, 64)
+			case "avg60":
+				stat.Avg60, _ = strconv.ParseFloat(value, 64)
+			case "avg300":
+				stat.Avg300, _ = strconv.ParseFloat(value, 64)
+			case "total":
+				stat.Total, _ = strconv.ParseUint(value, 10, 64)
+			}
+		}
+	}
+	return resource, scanner.Err()
+}
@@ -0,0 +1,153 @@
+package stats
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// Probe checks a target by connecting to Address, or by a GET of URL which is up when it answers
+// with a status below 400
+type Probe struct {
+	Name    string
+	Address string
+	URL     string
+	Timeout time.Duration
+}
+
+type ProbeData struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	Up     bool   `json:"up"`
+	// LatencyMs is the time the TCP connection took, ResponseMs the time until the HTTP response headers
+	LatencyMs  float64 `json:"latency_ms"`
+	ResponseMs float64 `json:"response_ms,omitempty"`
+	Status     int     `json:"status,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	// SuccessRatio is the share of the last Checks checks that succeeded
+	SuccessRatio float64 `json:"success_ratio"`
+	Checks       int     `json:"checks"`
+}
+
+// Probes runs the probes concurrently and keeps the results of the last Window checks of each. An
+// unreachable target is reported as down rather than as an error of the collector.
+type Probes struct {
+	Probes  []Probe
+	Window  int
+	history map[string][]bool
+}
+
+func (p *Probes) Collect() (probes []ProbeData, err error) {
+	if p.history == nil {
+		p.history = make(map[string][]bool)
+	}
+
+	probes = make([]ProbeData, len(p.Probes))
+	var wg sync.WaitGroup
+	for i, probe := range p.Probes {
+		wg.Add(1)
+		go func(i int, probe Probe) {
+			defer wg.Done()
+			probes[i] = probe.check()
+		}(i, probe)
+	}
+	wg.Wait()
+
+	window := p.Window
+	if window <= 0 {
+		window = 20
+	}
+	for i := range probes {
+		history := append(p.history[probes[i].Name], probes[i].Up)
+		if len(history) > window {
+			history = history[len(history)-window:]
+		}
+		p.history[probes[i].Name] = history
+
+		succeeded := 0
+		for _, up := range history {
+			if up {
+				succeeded++
+			}
+		}
+		probes[i].Checks = len(history)
+		probes[i].SuccessRatio = util.RoundTo(float64(succeeded)/float64(len(history)), 2)
+	}
+	return probes, nil
+}
+
+func (probe Probe) check() (data ProbeData) {
+	data = ProbeData{Name: probe.Name, Target: probe.Address}
+	timeout := probe.Timeout
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	if probe.URL != "" {
+		data.Target = probe.URL
+		return probe.checkHTTP(data, timeout)
+	}
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", probe.Address, timeout)
+	if err != nil {
+		data.Error = err.Error()
+		return data
+	}
+	conn.Close()
+	data.LatencyMs = milliseconds(time.Since(start))
+	data.Up = true
+	return data
+}
+
+func (probe Probe) checkHTTP(data ProbeData, timeout time.Duration) ProbeData {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var connectStart time.Time
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) { connectStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				data.LatencyMs = milliseconds(time.Since(connectStart))
+			}
+		},
+	}
+	request, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodGet, probe.URL, nil)
+	if err != nil {
+		data.Error = err.Error()
+		return data
+	}
+
+	// Every check opens a new connection and goes straight to the target, which may be the proxy itself
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             nil,
+			DisableKeepAlives: true,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		data.Error = err.Error()
+		return data
+	}
+	response.Body.Close()
+	data.ResponseMs = milliseconds(time.Since(start))
+	data.Status = response.StatusCode
+	data.Up = response.StatusCode < 400
+	if !data.Up {
+		data.Error = fmt.Sprintf("HTTP status %s", response.Status)
+	}
+	return data
+}
+
+func milliseconds(duration time.Duration) float64 {
+	return util.RoundTo(float64(duration)/float64(time.Millisecond), 2)
+}
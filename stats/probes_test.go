@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	probe := Probe{Name: "local", Address: address, Timeout: time.Second}
+
+	data := probe.check()
+	if !data.Up || data.Error != "" || data.Target != address {
+		t.Errorf("probe of an open listener: %+v", data)
+	}
+
+	listener.Close()
+	data = probe.check()
+	if data.Up || data.Error == "" || data.LatencyMs != 0 {
+		t.Errorf("probe of a closed listener: %+v", data)
+	}
+}
+
+func TestProbeHTTP(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "broken", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	data := Probe{Name: "ok", URL: ok.URL, Timeout: time.Second}.check()
+	if !data.Up || data.Status != http.StatusOK || data.Error != "" || data.Target != ok.URL {
+		t.Errorf("probe of a server answering 200: %+v", data)
+	}
+
+	data = Probe{Name: "failing", URL: failing.URL, Timeout: time.Second}.check()
+	if data.Up || data.Status != http.StatusInternalServerError || data.Error != "HTTP status 500 Internal Server Error" {
+		t.Errorf("probe of a server answering 500: %+v", data)
+	}
+
+	start := time.Now()
+	data = Probe{Name: "slow", URL: slow.URL, Timeout: 100 * time.Millisecond}.check()
+	if data.Up || data.Status != 0 || data.Error == "" {
+		t.Errorf("probe of a server that does not answer: %+v", data)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the probe took %s despite its timeout of 100ms", elapsed)
+	}
+}
+
+func TestProbesSuccessRatio(t *testing.T) {
+	var status atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	probes := &Probes{Probes: []Probe{{Name: "web", URL: server.URL, Timeout: time.Second}}, Window: 3}
+	for i, step := range []struct {
+		status int
+		ratio  float64
+		checks int
+	}{
+		{http.StatusOK, 1, 1},
+		{http.StatusServiceUnavailable, 0.5, 2},
+		{http.StatusOK, 0.67, 3},
+		// The first check falls out of the window of 3
+		{http.StatusServiceUnavailable, 0.33, 3},
+		{http.StatusServiceUnavailable, 0.33, 3},
+		{http.StatusServiceUnavailable, 0, 3},
+	} {
+		status.Store(int32(step.status))
+		data, err := probes.Collect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != 1 {
+			t.Fatalf("run %d returned %d probes", i, len(data))
+		}
+		if data[0].Up != (step.status == http.StatusOK) || data[0].SuccessRatio != step.ratio || data[0].Checks != step.checks {
+			t.Errorf("run %d: up %v, success ratio %v over %d checks, want %v over %d", i, data[0].Up, data[0].SuccessRatio, data[0].Checks, step.ratio, step.checks)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+var procPath = "/proc"
+
+// USER_HZ, the unit of the times in /proc/<pid>/stat, is 100 on every architecture Linux supports
+var clockTicks = 100.0
+
+type ProcessData struct {
+	PID        int     `json:"pid"`
+	Name       string  `json:"name"`
+	Cmdline    string  `json:"cmdline"`
+	User       string  `json:"user"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"`
+	State      string  `json:"state"`
+	Threads    uint64  `json:"threads"`
+}
+
+type TopProcessesData struct {
+	Total    int           `json:"total"`
+	ByCPU    []ProcessData `json:"by_cpu"`
+	ByMemory []ProcessData `json:"by_memory"`
+}
+
+type processStat struct {
+	pid       int
+	name      string
+	state     string
+	ticks     uint64
+	threads   uint64
+	startTime uint64
+	rss       uint64
+}
+
+type processTicks struct {
+	ticks     uint64
+	startTime uint64
+}
+
+// TopProcesses reports the processes using the most CPU and memory. CPU usage is computed from the
+// difference in CPU time between two calls to Collect, so the first call lists no processes by CPU.
+type TopProcesses struct {
+	Count         int
+	CmdlineLength int
+	previous      map[int]processTicks
+	previousAt    time.Time
+	users         map[string]string
+}
+
+func (t *TopProcesses) Collect() (topProcesses TopProcessesData, err error) {
+	if t.users == nil {
+		t.users = make(map[string]string)
+	}
+
+	processStats, err := readProcessStats()
+	if err != nil {
+		return topProcesses, err
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(t.previousAt).Seconds()
+	current := make(map[int]processTicks)
+	var processes []ProcessData
+	for _, stat := range processStats {
+		current[stat.pid] = processTicks{ticks: stat.ticks, startTime: stat.startTime}
+		processes = append(processes, ProcessData{
+			PID:        stat.pid,
+			Name:       stat.name,
+			CPUPercent: cpuPercentSince(stat, t.previous, elapsed),
+			RSS:        stat.rss,
+			State:      stat.state,
+			Threads:    stat.threads,
+		})
+	}
+	firstRun := t.previous == nil
+	t.previous = current
+	t.previousAt = now
+
+	topProcesses.Total = len(processes)
+	topProcesses.ByCPU = []ProcessData{}
+	if !firstRun {
+		sort.SliceStable(processes, func(i, j int) bool { return processes[i].CPUPercent > processes[j].CPUPercent })
+		topProcesses.ByCPU = t.details(processes)
+	}
+	sort.SliceStable(processes, func(i, j int) bool { return processes[i].RSS > processes[j].RSS })
+	topProcesses.ByMemory = t.details(processes)
+	return topProcesses, nil
+}
+
+// details fills in the command line and user of the first Count processes, which are too
+// expensive to read for all of them
+func (t *TopProcesses) details(processes []ProcessData) (top []ProcessData) {
+	count := t.Count
+	if count <= 0 || count > len(processes) {
+		count = len(processes)
+	}
+	top = make([]ProcessData, 0, count)
+	for _, process := range processes[:count] {
+		process.Cmdline = readProcessCmdline(process.PID, t.CmdlineLength)
+		process.User = t.processUser(process.PID)
+		top = append(top, process)
+	}
+	return top
+}
+
+func (t *TopProcesses) processUser(pid int) string {
+	uid, err := readProcessUID(pid)
+	if err != nil {
+		return ""
+	}
+	if name, ok := t.users[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	t.users[uid] = name
+	return name
+}
+
+// readProcessStats reads the stat of every process, skipping the ones that exit in the meantime
+func readProcessStats() (processStats []processStat, err error) {
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return processStats, err
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := readProcessStat(pid)
+		if err != nil {
+			continue
+		}
+		processStats = append(processStats, stat)
+	}
+	return processStats, nil
+}
+
+// cpuPercentSince returns the CPU usage of a process since the previous sample. A different start
+// time means the PID was reused by another process, which has no usable previous sample.
+func cpuPercentSince(stat processStat, previous map[int]processTicks, elapsed float64) float64 {
+	last, ok := previous[stat.pid]
+	if !ok || last.startTime != stat.startTime || elapsed <= 0 || stat.ticks < last.ticks {
+		return 0
+	}
+	return util.RoundTo(float64(stat.ticks-last.ticks)/clockTicks/elapsed*100, 2)
+}
+
+func readProcessStat(pid int) (stat processStat, err error) {
+	contents, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return stat, err
+	}
+
+	// The name is enclosed in parentheses and may itself contain spaces and parentheses
+	line := string(contents)
+	start := strings.IndexByte(line, '(')
+	end := strings.LastIndexByte(line, ')')
+	if start == -1 || end < start {
+		return stat, fmt.Errorf("malformed stat of PID %d", pid)
+	}
+	fields := strings.Fields(line[end+1:])
+	// fields[0] is field 3 of proc(5), the state
+	if len(fields) < 22 {
+		return stat, fmt.Errorf("malformed stat of PID %d", pid)
+	}
+
+	stat.pid = pid
+	stat.name = line[start+1 : end]
+	stat.state = fields[0]
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	stat.ticks = utime + stime
+	stat.threads, _ = strconv.ParseUint(fields[17], 10, 64)
+	stat.startTime, _ = strconv.ParseUint(fields[19], 10, 64)
+	rssPages, _ := strconv.ParseInt(fields[21], 10, 64)
+	if rssPages > 0 {
+		stat.rss = uint64(rssPages) * uint64(os.Getpagesize())
+	}
+	return stat, nil
+}
+
+func readProcessCmdline(pid, length int) string {
+	contents, err := os.ReadFile(filepath.Join(procPath, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return ""
+	}
+	cmdline := strings.TrimSpace(strings.ReplaceAll(string(contents), "\x00", " "))
+	if runes := []rune(cmdline); length > 0 && len(runes) > length {
+		cmdline = string(runes[:length])
+	}
+	return cmdline
+}
+
+func readProcessUID(pid int) (uid string, err error) {
+	f, err := os.Open(filepath.Join(procPath, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return uid, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 && fields[0] == "Uid:" {
+			return fields[1], nil
+		}
+	}
+	return uid, fmt.Errorf("no Uid in the status of PID %d", pid)
+}
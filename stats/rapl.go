@@ -0,0 +1,113 @@
+package stats
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+var powercapPath = "/sys/class/powercap"
+
+// RaplZone is one RAPL domain, e.g. package-0 or its core, uncore and dram subzones. Readable is
+// false when energy_uj cannot be read, which is root only on most current kernels.
+type RaplZone struct {
+	Zone     string  `json:"zone"`
+	Name     string  `json:"name"`
+	Readable bool    `json:"readable"`
+	PowerW   float64 `json:"power_w"`
+}
+
+// RaplData adds up the power draw in watts of the zones by domain
+type RaplData struct {
+	Readable bool       `json:"readable"`
+	Package  float64    `json:"package_w"`
+	Core     float64    `json:"core_w"`
+	Uncore   float64    `json:"uncore_w"`
+	DRAM     float64    `json:"dram_w"`
+	Psys     float64    `json:"psys_w"`
+	Zones    []RaplZone `json:"zones"`
+}
+
+type raplCounter struct {
+	energy uint64
+	at     time.Time
+}
+
+// Rapl computes the power draw from the difference between the energy counters of two calls to
+// Collect, so the first call reports 0 W
+type Rapl struct {
+	previous map[string]raplCounter
+}
+
+func (r *Rapl) Collect() (rapl RaplData, err error) {
+	// intel-rapl-mmio zones duplicate the package zones of intel-rapl on recent Intel CPUs
+	zones, err := filepath.Glob(filepath.Join(powercapPath, "intel-rapl:*"))
+	if err != nil {
+		return rapl, err
+	}
+	sortByIndex(zones, "intel-rapl:")
+
+	current := make(map[string]raplCounter)
+	rapl.Zones = []RaplZone{}
+	for _, zone := range zones {
+		raplZone := RaplZone{
+			Zone: filepath.Base(zone),
+			Name: readSysfsString(filepath.Join(zone, "name")),
+		}
+		energy, err := strconv.ParseUint(readSysfsString(filepath.Join(zone, "energy_uj")), 10, 64)
+		if err == nil {
+			raplZone.Readable = true
+			rapl.Readable = true
+			now := time.Now()
+			current[raplZone.Zone] = raplCounter{energy: energy, at: now}
+			if previous, ok := r.previous[raplZone.Zone]; ok {
+				raplZone.PowerW = raplPower(previous, energy, now, filepath.Join(zone, "max_energy_range_uj"))
+			}
+		}
+
+		switch domain, _, _ := strings.Cut(raplZone.Name, "-"); domain {
+		case "package":
+			rapl.Package += raplZone.PowerW
+		case "core":
+			rapl.Core += raplZone.PowerW
+		case "uncore":
+			rapl.Uncore += raplZone.PowerW
+		case "dram":
+			rapl.DRAM += raplZone.PowerW
+		case "psys":
+			rapl.Psys += raplZone.PowerW
+		}
+		rapl.Zones = append(rapl.Zones, raplZone)
+	}
+	r.previous = current
+
+	rapl.Package = util.RoundTo(rapl.Package, 2)
+	rapl.Core = util.RoundTo(rapl.Core, 2)
+	rapl.Uncore = util.RoundTo(rapl.Uncore, 2)
+	rapl.DRAM = util.RoundTo(rapl.DRAM, 2)
+	rapl.Psys = util.RoundTo(rapl.Psys, 2)
+	return rapl, nil
+}
+
+// raplPower returns the watts drawn since the previous reading. The counter wraps around at
+// max_energy_range_uj, in which case the range is added back to the difference.
+func raplPower(previous raplCounter, energy uint64, now time.Time, maxEnergyRangePath string) float64 {
+	elapsed := now.Sub(previous.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	var delta uint64
+	if energy >= previous.energy {
+		delta = energy - previous.energy
+	} else {
+		maxEnergyRange, err := strconv.ParseUint(readSysfsString(maxEnergyRangePath), 10, 64)
+		if err != nil || maxEnergyRange < previous.energy {
+			return 0
+		}
+		delta = maxEnergyRange - previous.energy + energy
+	}
+	return util.RoundTo(float64(delta)/1e6/elapsed, 2)
+}
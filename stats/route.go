@@ -0,0 +1,330 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	sysClassNetPath = "/sys/class/net"
+	resolvConfPath  = "/etc/resolv.conf"
+	// systemd-resolved lists the upstream servers here when /etc/resolv.conf points to its stub
+	resolvedConfPath = "/run/systemd/resolve/resolv.conf"
+)
+
+const (
+	routeFlagUp     = 0x1
+	routeFlagReject = 0x200
+)
+
+// DefaultRoute is the default route of one address family, Address being the primary address of
+// its interface
+type DefaultRoute struct {
+	Interface string `json:"interface"`
+	Gateway   string `json:"gateway"`
+	Metric    uint64 `json:"metric"`
+	Address   string `json:"address"`
+}
+
+type DNSData struct {
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+	// Resolver is systemd-resolved when resolv.conf points to its stub, Upstream then lists the
+	// servers it forwards to
+	Resolver string   `json:"resolver"`
+	Upstream []string `json:"upstream"`
+}
+
+// VPNData tells whether the traffic to the internet goes through a tunnel interface
+type VPNData struct {
+	Active    bool   `json:"active"`
+	Interface string `json:"interface"`
+	Type      string `json:"type"`
+}
+
+type NetworkContextData struct {
+	IPv4 *DefaultRoute `json:"ipv4"`
+	IPv6 *DefaultRoute `json:"ipv6"`
+	DNS  DNSData       `json:"dns"`
+	VPN  VPNData       `json:"vpn"`
+}
+
+// GetNetworkContext reports where the traffic goes: the default routes, the resolvers and whether
+// a VPN carries the traffic to the internet. A family without a default route is reported as null.
+func GetNetworkContext() (networkContext NetworkContextData, err error) {
+	networkContext.IPv4, err = readDefaultRoute4()
+	if err != nil {
+		return networkContext, err
+	}
+	networkContext.IPv6, err = readDefaultRoute6()
+	if err != nil {
+		return networkContext, err
+	}
+	networkContext.DNS = readDNS()
+
+	networkContext.VPN = detectVPN(networkContext.IPv4, networkContext.IPv6)
+	return networkContext, nil
+}
+
+// Public addresses whose route tells where the traffic to the internet goes
+var (
+	publicAddress4 = net.ParseIP("1.1.1.1")
+	publicAddress6 = net.ParseIP("2606:4700:4700::1111")
+)
+
+// detectVPN looks for a tunnel carrying the traffic to the internet. The kernel is asked for the
+// route to a public address first, which covers the policy routing of wg-quick. Without an answer
+// the routing table is checked for a tunnel holding the default route or the 0.0.0.0/1 and
+// 128.0.0.0/1 routes that OpenVPN adds with redirect-gateway def1.
+func detectVPN(ipv4, ipv6 *DefaultRoute) (vpn VPNData) {
+	routes := []*DefaultRoute{ipv4, ipv6}
+	var candidates []string
+	for i, destination := range []net.IP{publicAddress4, publicAddress6} {
+		if routes[i] == nil {
+			continue
+		}
+		if name, err := routeInterface(destination); err == nil {
+			candidates = append(candidates, name)
+		}
+	}
+	if splitInterface, err := readSplitDefaultRoute4(); err == nil && splitInterface != "" {
+		candidates = append(candidates, splitInterface)
+	}
+	for _, route := range routes {
+		if route != nil {
+			candidates = append(candidates, route.Interface)
+		}
+	}
+	for _, name := range candidates {
+		if vpnType := tunnelType(name); vpnType != "" {
+			return VPNData{Active: true, Interface: name, Type: vpnType}
+		}
+	}
+	return vpn
+}
+
+// route4 is a line of /proc/net/route, whose addresses are in host byte order
+type route4 struct {
+	iface       string
+	destination string
+	gateway     string
+	mask        string
+	metric      uint64
+}
+
+func readRoutes4() (routes []route4, err error) {
+	f, err := os.Open(filepath.Join(procPath, "net", "route"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return routes, nil
+		}
+		return routes, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 64)
+		if flags&routeFlagUp == 0 || flags&routeFlagReject != 0 {
+			continue
+		}
+		metric, _ := strconv.ParseUint(fields[6], 10, 64)
+		routes = append(routes, route4{iface: fields[0], destination: fields[1], gateway: fields[2], mask: fields[7], metric: metric})
+	}
+	return routes, scanner.Err()
+}
+
+// readSplitDefaultRoute4 returns the interface holding both halves of the address space, the
+// 0.0.0.0/1 and 128.0.0.0/1 routes which take precedence over the default route
+func readSplitDefaultRoute4() (name string, err error) {
+	routes, err := readRoutes4()
+	if err != nil {
+		return name, err
+	}
+	lower, upper := make(map[string]bool), make(map[string]bool)
+	for _, route := range routes {
+		// 128.0.0.0 reads as 00000080 in host byte order, as does the /1 mask
+		if route.mask != "00000080" {
+			continue
+		}
+		switch route.destination {
+		case "00000000":
+			lower[route.iface] = true
+		case "00000080":
+			upper[route.iface] = true
+		}
+	}
+	for _, route := range routes {
+		if lower[route.iface] && upper[route.iface] {
+			return route.iface, nil
+		}
+	}
+	return name, nil
+}
+
+// readDefaultRoute4 picks the default route with the lowest metric from /proc/net/route
+func readDefaultRoute4() (route *DefaultRoute, err error) {
+	routes, err := readRoutes4()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range routes {
+		if entry.destination != "00000000" || entry.mask != "00000000" {
+			continue
+		}
+		if route != nil && entry.metric >= route.Metric {
+			continue
+		}
+		gateway, err := parseHostOrderIP(entry.gateway)
+		if err != nil {
+			continue
+		}
+		route = &DefaultRoute{Interface: entry.iface, Gateway: gateway.String(), Metric: entry.metric}
+	}
+	if route != nil {
+		route.Address = primaryAddress(route.Interface, false)
+	}
+	return route, nil
+}
+
+// readDefaultRoute6 picks the default route with the lowest metric from /proc/net/ipv6_route,
+// whose addresses are in network byte order unlike the ones of /proc/net/route
+func readDefaultRoute6() (route *DefaultRoute, err error) {
+	f, err := os.Open(filepath.Join(procPath, "net", "ipv6_route"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Destination PrefixLength Source PrefixLength NextHop Metric RefCnt Use Flags Iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[1] != "00" || strings.Trim(fields[0], "0") != "" || fields[9] == "lo" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 64)
+		if flags&routeFlagUp == 0 || flags&routeFlagReject != 0 {
+			continue
+		}
+		metric, _ := strconv.ParseUint(fields[5], 16, 64)
+		if route != nil && metric >= route.Metric {
+			continue
+		}
+		gateway, err := hex.DecodeString(fields[4])
+		if err != nil || len(gateway) != net.IPv6len {
+			continue
+		}
+		route = &DefaultRoute{Interface: fields[9], Gateway: net.IP(gateway).String(), Metric: metric}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if route != nil {
+		route.Address = primaryAddress(route.Interface, true)
+	}
+	return route, nil
+}
+
+// primaryAddress returns the first address of the interface in the family, preferring global
+// IPv6 addresses over link local ones
+func primaryAddress(name string, ipv6 bool) string {
+	netInterface, err := net.InterfaceByName(name)
+	if err != nil {
+		return ""
+	}
+	addresses, err := netInterface.Addrs()
+	if err != nil {
+		return ""
+	}
+	fallback := ""
+	for _, address := range addresses {
+		ipNet, ok := address.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP.String()
+		}
+		if fallback == "" {
+			fallback = ipNet.IP.String()
+		}
+	}
+	return fallback
+}
+
+func readDNS() (dns DNSData) {
+	dns.Nameservers, dns.Search = readResolvConf(resolvConfPath)
+	for _, nameserver := range dns.Nameservers {
+		if nameserver == "127.0.0.53" || nameserver == "127.0.0.54" {
+			dns.Resolver = "systemd-resolved"
+			dns.Upstream, _ = readResolvConf(resolvedConfPath)
+			break
+		}
+	}
+	if dns.Upstream == nil {
+		dns.Upstream = []string{}
+	}
+	return dns
+}
+
+func readResolvConf(path string) (nameservers, search []string) {
+	nameservers = []string{}
+	search = []string{}
+	f, err := os.Open(path)
+	if err != nil {
+		return nameservers, search
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			// The last of search and domain wins
+			search = fields[1:]
+		}
+	}
+	return nameservers, search
+}
+
+// tunnelType tells which kind of VPN an interface is, or returns an empty string for other interfaces
+func tunnelType(name string) string {
+	uevent := readSysfsString(filepath.Join(sysClassNetPath, name, "uevent"))
+	if strings.Contains(uevent, "DEVTYPE=wireguard") || strings.HasPrefix(name, "wg") {
+		return "wireguard"
+	}
+	// ARPHRD_PPP and ARPHRD_NONE, the type of tun devices
+	switch readSysfsString(filepath.Join(sysClassNetPath, name, "type")) {
+	case "512":
+		return "ppp"
+	case "65534":
+		return "tun"
+	}
+	for _, prefix := range []string{"tun", "tap", "ppp", "utun"} {
+		if strings.HasPrefix(name, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
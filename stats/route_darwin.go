@@ -0,0 +1,10 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+)
+
+func routeInterface(destination net.IP) (name string, err error) {
+	return name, fmt.Errorf("resolving routes is only available on Linux")
+}
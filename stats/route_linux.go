@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// routeInterface asks the kernel which interface it would send a packet to destination through,
+// with RTM_GETROUTE. Unlike the main routing table this follows the policy rules, such as the
+// fwmark rule wg-quick sends everything but its own packets to a separate table with.
+func routeInterface(destination net.IP) (name string, err error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return name, err
+	}
+	defer unix.Close(fd)
+	if err = unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return name, err
+	}
+	if err = unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &unix.Timeval{Sec: 1}); err != nil {
+		return name, err
+	}
+
+	family, address := uint8(unix.AF_INET6), destination.To16()
+	if destination.To4() != nil {
+		family, address = unix.AF_INET, destination.To4()
+	}
+	attribute := netlinkAttribute(unix.RTA_DST, address)
+	length := unix.SizeofNlMsghdr + unix.SizeofRtMsg + len(attribute)
+	request := make([]byte, length)
+	binary.NativeEndian.PutUint32(request[0:4], uint32(length))
+	binary.NativeEndian.PutUint16(request[4:6], unix.RTM_GETROUTE)
+	binary.NativeEndian.PutUint16(request[6:8], unix.NLM_F_REQUEST)
+	binary.NativeEndian.PutUint32(request[8:12], 1)
+	// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type, flags
+	request[unix.SizeofNlMsghdr] = family
+	request[unix.SizeofNlMsghdr+1] = uint8(len(address) * 8)
+	copy(request[unix.SizeofNlMsghdr+unix.SizeofRtMsg:], attribute)
+	if err = unix.Sendto(fd, request, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return name, err
+	}
+
+	buffer := make([]byte, 8192)
+	n, _, err := unix.Recvfrom(fd, buffer, 0)
+	if err != nil {
+		return name, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(buffer[:n])
+	if err != nil {
+		return name, err
+	}
+	for _, message := range messages {
+		if message.Header.Type == unix.NLMSG_ERROR {
+			if len(message.Data) >= 4 {
+				if errno := int32(binary.NativeEndian.Uint32(message.Data[0:4])); errno != 0 {
+					return name, syscall.Errno(-errno)
+				}
+			}
+			continue
+		}
+		if message.Header.Type != unix.RTM_NEWROUTE {
+			continue
+		}
+		ifindex, ok := parseRouteReply(message.Data)
+		if !ok {
+			break
+		}
+		netInterface, err := net.InterfaceByIndex(ifindex)
+		if err != nil {
+			return name, err
+		}
+		return netInterface.Name, nil
+	}
+	return name, fmt.Errorf("no route to %s", destination)
+}
+
+// parseRouteReply returns the output interface of an RTM_NEWROUTE message, a struct rtmsg followed
+// by route attributes
+func parseRouteReply(data []byte) (ifindex int, ok bool) {
+	if len(data) < unix.SizeofRtMsg {
+		return ifindex, false
+	}
+	oif, found := parseNetlinkAttributes(data[unix.SizeofRtMsg:])[unix.RTA_OIF]
+	if !found || len(oif) < 4 {
+		return ifindex, false
+	}
+	return int(binary.NativeEndian.Uint32(oif)), true
+}
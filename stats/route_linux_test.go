@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseRouteReply(t *testing.T) {
+	// The answer to RTM_GETROUTE for 1.1.1.1 under wg-quick: the route comes from table 51820
+	// and leaves through wg0, interface 7
+	rtmsg := make([]byte, unix.SizeofRtMsg)
+	rtmsg[0] = unix.AF_INET
+	rtmsg[1] = 32
+	rtmsg[4] = unix.RT_TABLE_COMPAT
+	table := make([]byte, 4)
+	binary.NativeEndian.PutUint32(table, 51820)
+	oif := make([]byte, 4)
+	binary.NativeEndian.PutUint32(oif, 7)
+	reply := append(rtmsg, netlinkAttribute(unix.RTA_TABLE, table)...)
+	reply = append(reply, netlinkAttribute(unix.RTA_DST, []byte{1, 1, 1, 1})...)
+	reply = append(reply, netlinkAttribute(unix.RTA_OIF, oif)...)
+	reply = append(reply, netlinkAttribute(unix.RTA_PREFSRC, []byte{10, 8, 0, 2})...)
+
+	if ifindex, ok := parseRouteReply(reply); !ok || ifindex != 7 {
+		t.Errorf("parsed interface %d (%v), want 7", ifindex, ok)
+	}
+	// An unreachable destination has no output interface
+	if _, ok := parseRouteReply(append(rtmsg[:len(rtmsg):len(rtmsg)], netlinkAttribute(unix.RTA_DST, []byte{1, 1, 1, 1})...)); ok {
+		t.Error("parsed an interface from a reply without RTA_OIF")
+	}
+	if _, ok := parseRouteReply(rtmsg[:4]); ok {
+		t.Error("parsed an interface from a truncated reply")
+	}
+}
+
+func TestRouteInterface(t *testing.T) {
+	name, err := routeInterface(publicAddress4)
+	if err != nil {
+		t.Skipf("no route to %s: %s", publicAddress4, err.Error())
+	}
+	if name == "" || name == "lo" {
+		t.Errorf("the route to %s leaves through %q", publicAddress4, name)
+	}
+}
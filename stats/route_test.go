@@ -0,0 +1,86 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A laptop on Wi-Fi with OpenVPN connected with redirect-gateway def1, which leaves the default
+// route alone and adds the two halves of the address space through the tunnel
+const openVPNDef1Routes = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+tun0	00000000	0100080A	0003	0	0	0	00000080	0	0	0
+tun0	0000080A	00000000	0001	0	0	0	00FFFFFF	0	0	0
+tun0	00000080	0100080A	0003	0	0	0	00000080	0	0	0
+wlan0	0001A8C0	00000000	0001	0	0	600	00FFFFFF	0	0	0
+`
+
+// wg-quick sends everything to a separate table through a fwmark rule, the main table keeps the
+// default route of the physical interface
+const wgQuickRoutes = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0101A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0001A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+`
+
+// useRouteFixture points procPath and sysClassNetPath to a routing table and to interfaces of the
+// given types (65534 for tun devices) for the duration of the test
+func useRouteFixture(t *testing.T, routes string, interfaces map[string]string) {
+	dir := t.TempDir()
+	previousProcPath, previousSysClassNetPath := procPath, sysClassNetPath
+	t.Cleanup(func() {
+		procPath, sysClassNetPath = previousProcPath, previousSysClassNetPath
+	})
+	procPath = filepath.Join(dir, "proc")
+	sysClassNetPath = filepath.Join(dir, "net")
+	if err := os.MkdirAll(filepath.Join(procPath, "net"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(procPath, "net", "route"), []byte(routes), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, deviceType := range interfaces {
+		if err := os.MkdirAll(filepath.Join(sysClassNetPath, name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(sysClassNetPath, name, "type"), []byte(deviceType+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenVPNDef1Routes(t *testing.T) {
+	useRouteFixture(t, openVPNDef1Routes, map[string]string{"wlan0": "1", "tun0": "65534"})
+
+	route, err := readDefaultRoute4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default route is untouched, the tunnel only shows in the split routes
+	if route == nil || route.Interface != "wlan0" || route.Gateway != "192.168.1.1" || route.Metric != 600 {
+		t.Errorf("default route %+v, want wlan0 via 192.168.1.1", route)
+	}
+	name, err := readSplitDefaultRoute4()
+	if err != nil || name != "tun0" {
+		t.Errorf("split default route through %q (%v), want tun0", name, err)
+	}
+	if vpn := detectVPN(route, nil); !vpn.Active || vpn.Interface != "tun0" || vpn.Type != "tun" {
+		t.Errorf("detected %+v, want the tun0 tunnel", vpn)
+	}
+}
+
+func TestWgQuickRoutes(t *testing.T) {
+	useRouteFixture(t, wgQuickRoutes, map[string]string{"eth0": "1"})
+
+	route, err := readDefaultRoute4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route == nil || route.Interface != "eth0" {
+		t.Errorf("default route %+v, want eth0", route)
+	}
+	// Nothing in the main table tells about the tunnel, only asking the kernel for the route does
+	if name, err := readSplitDefaultRoute4(); err != nil || name != "" {
+		t.Errorf("split default route through %q (%v), want none", name, err)
+	}
+}
@@ -0,0 +1,285 @@
+package stats
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// tcpStates names the hexadecimal st column of /proc/net/tcp
+var tcpStates = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+}
+
+// ListeningPort is a listening TCP socket or an unconnected UDP socket. PID and Process are only
+// known for the sockets of processes wsstats may inspect.
+type ListeningPort struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     int    `json:"port"`
+	PID      int    `json:"pid"`
+	Process  string `json:"process"`
+}
+
+type SocketsData struct {
+	TCP       int             `json:"tcp"`
+	UDP       int             `json:"udp"`
+	TCPStates map[string]int  `json:"tcp_states"`
+	Listening []ListeningPort `json:"listening"`
+	// RetransmitPercent is the share of the segments sent since the previous sample that were retransmissions
+	RetransmitsPerSec float64 `json:"retransmits_per_sec"`
+	RetransmitPercent float64 `json:"retransmit_percent"`
+}
+
+type socketEntry struct {
+	protocol string
+	address  string
+	port     int
+	state    string
+	inode    string
+}
+
+// Sockets counts the TCP and UDP sockets by state, lists the listening ports and computes the TCP
+// retransmit rate between calls to Collect
+type Sockets struct {
+	previousRetransmits uint64
+	previousOutSegments uint64
+	previousAt          time.Time
+}
+
+func (s *Sockets) Collect() (sockets SocketsData, err error) {
+	sockets.TCPStates = make(map[string]int)
+	for _, state := range []string{"established", "time_wait", "close_wait", "listen"} {
+		sockets.TCPStates[state] = 0
+	}
+
+	var listening []socketEntry
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		entries, err := readSocketTable(protocol)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// IPv6 may be disabled
+				continue
+			}
+			return sockets, err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(protocol, "tcp") {
+				sockets.TCP++
+				sockets.TCPStates[entry.state]++
+				if entry.state == "listen" {
+					listening = append(listening, entry)
+				}
+			} else {
+				sockets.UDP++
+				if entry.state == "close" {
+					listening = append(listening, entry)
+				}
+			}
+		}
+	}
+	sockets.Listening = listeningPorts(listening)
+
+	retransmits, outSegments, err := readTCPSegments()
+	if err != nil {
+		return sockets, err
+	}
+	now := time.Now()
+	if !s.previousAt.IsZero() && retransmits >= s.previousRetransmits && outSegments >= s.previousOutSegments {
+		if elapsed := now.Sub(s.previousAt).Seconds(); elapsed > 0 {
+			sockets.RetransmitsPerSec = util.RoundTo(float64(retransmits-s.previousRetransmits)/elapsed, 2)
+		}
+		if sent := outSegments - s.previousOutSegments; sent > 0 {
+			sockets.RetransmitPercent = util.RoundTo(float64(retransmits-s.previousRetransmits)/float64(sent)*100, 2)
+		}
+	}
+	s.previousRetransmits = retransmits
+	s.previousOutSegments = outSegments
+	s.previousAt = now
+	return sockets, nil
+}
+
+// listeningPorts finds the processes owning the sockets and merges duplicates, e.g. several
+// SO_REUSEPORT sockets on the same port
+func listeningPorts(entries []socketEntry) (ports []ListeningPort) {
+	ports = []ListeningPort{}
+	if len(entries) == 0 {
+		return ports
+	}
+	owners := socketOwners()
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		key := fmt.Sprintf("%s %s %d", entry.protocol, entry.address, entry.port)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		port := ListeningPort{Protocol: entry.protocol, Address: entry.address, Port: entry.port}
+		if pid, ok := owners[entry.inode]; ok {
+			port.PID = pid
+			port.Process = readSysfsString(filepath.Join(procPath, strconv.Itoa(pid), "comm"))
+		}
+		ports = append(ports, port)
+	}
+	sort.SliceStable(ports, func(i, j int) bool {
+		if ports[i].Port != ports[j].Port {
+			return ports[i].Port < ports[j].Port
+		}
+		return ports[i].Protocol < ports[j].Protocol
+	})
+	return ports
+}
+
+// socketOwners maps socket inodes to the PID of a process holding them open
+func socketOwners() (owners map[string]int) {
+	owners = make(map[string]int)
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return owners
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procPath, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, found := strings.CutPrefix(target, "socket:["); found {
+				inode = strings.TrimSuffix(inode, "]")
+				if _, ok := owners[inode]; !ok {
+					owners[inode] = pid
+				}
+			}
+		}
+	}
+	return owners
+}
+
+func readSocketTable(protocol string) (entries []socketEntry, err error) {
+	f, err := os.Open(filepath.Join(procPath, "net", protocol))
+	if err != nil {
+		return entries, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		address, port, err := parseSocketAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		state, ok := tcpStates[fields[3]]
+		if !ok {
+			state = strings.ToLower(fields[3])
+		}
+		entries = append(entries, socketEntry{
+			protocol: protocol,
+			address:  address,
+			port:     port,
+			state:    state,
+			inode:    fields[9],
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// parseSocketAddress decodes addresses such as 0100007F:0016
+func parseSocketAddress(value string) (address string, port int, err error) {
+	hexAddress, hexPort, found := strings.Cut(value, ":")
+	if !found {
+		return address, port, fmt.Errorf("malformed socket address \"%s\"", value)
+	}
+	ip, err := parseHostOrderIP(hexAddress)
+	if err != nil {
+		return address, port, err
+	}
+	parsedPort, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return address, port, fmt.Errorf("malformed socket address \"%s\"", value)
+	}
+	return ip.String(), int(parsedPort), nil
+}
+
+// parseHostOrderIP decodes the hexadecimal addresses of /proc/net, made of 32 bit words in host
+// byte order, which is little endian on every platform this runs on
+func parseHostOrderIP(value string) (ip net.IP, err error) {
+	bytes, err := hex.DecodeString(value)
+	if err != nil || (len(bytes) != net.IPv4len && len(bytes) != net.IPv6len) {
+		return ip, fmt.Errorf("malformed address \"%s\"", value)
+	}
+	for i := 0; i < len(bytes); i += 4 {
+		bytes[i], bytes[i+1], bytes[i+2], bytes[i+3] = bytes[i+3], bytes[i+2], bytes[i+1], bytes[i]
+	}
+	return net.IP(bytes), nil
+}
+
+// readTCPSegments returns the RetransSegs and OutSegs counters of /proc/net/snmp, which has a
+// line of names followed by a line of values for each protocol
+func readTCPSegments() (retransmits, outSegments uint64, err error) {
+	f, err := os.Open(filepath.Join(procPath, "net", "snmp"))
+	if err != nil {
+		return retransmits, outSegments, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "Tcp:" {
+			continue
+		}
+		if names == nil {
+			names = fields
+			continue
+		}
+		for i, name := range names {
+			if i >= len(fields) {
+				break
+			}
+			switch name {
+			case "RetransSegs":
+				retransmits, _ = strconv.ParseUint(fields[i], 10, 64)
+			case "OutSegs":
+				outSegments, _ = strconv.ParseUint(fields[i], 10, 64)
+			}
+		}
+		return retransmits, outSegments, nil
+	}
+	if err = scanner.Err(); err != nil {
+		return retransmits, outSegments, err
+	}
+	return retransmits, outSegments, fmt.Errorf("no Tcp counters in %s", filepath.Join(procPath, "net", "snmp"))
+}
@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// TemperatureSensor holds degrees Celsius, High and Critical are 0 when the chip has no such limit
+type TemperatureSensor struct {
+	Label       string  `json:"label"`
+	Temperature float64 `json:"temperature"`
+	High        float64 `json:"high"`
+	Critical    float64 `json:"critical"`
+}
+
+type FanData struct {
+	Label string `json:"label"`
+	RPM   uint64 `json:"rpm"`
+}
+
+type TemperatureChip struct {
+	Name string `json:"name"`
+	// Device is the hwmon or thermal zone directory the chip was read from
+	Device  string              `json:"device"`
+	Max     float64             `json:"max"`
+	Sensors []TemperatureSensor `json:"sensors"`
+	Fans    []FanData           `json:"fans"`
+}
+
+type TemperatureData struct {
+	// CPU is the temperature of the primary CPU sensor, named by CPUSensor as chip/label
+	CPU       float64           `json:"cpu"`
+	CPUSensor string            `json:"cpu_sensor"`
+	Chips     []TemperatureChip `json:"chips"`
+}
+
+// Temperatures reads the hwmon chips and the thermal zones on Linux and the SMC sensors on macOS.
+// CPUSensor selects the primary CPU
+// temperature as "chip/label", or "chip" for the hottest sensor of a chip, and is detected from
+// well known chips when empty. A machine without sensors reports no chips rather than an error.
+type Temperatures struct {
+	CPUSensor string
+}
+
+func (t *Temperatures) Collect() (temperatures TemperatureData, err error) {
+	chips, err := readTemperatureChips()
+	if err != nil {
+		return temperatures, err
+	}
+
+	// Several chips can share a driver name, e.g. one nvme chip per drive
+	seen := make(map[string]int)
+	for i := range chips {
+		seen[chips[i].Name]++
+		if seen[chips[i].Name] > 1 {
+			chips[i].Name = fmt.Sprintf("%s_%d", chips[i].Name, seen[chips[i].Name])
+		}
+	}
+	temperatures.Chips = chips
+
+	candidates := cpuSensors
+	if t.CPUSensor != "" {
+		candidates = []string{t.CPUSensor}
+	}
+	for _, candidate := range candidates {
+		if temperature, found := findTemperature(chips, candidate); found {
+			temperatures.CPU = temperature
+			temperatures.CPUSensor = candidate
+			break
+		}
+	}
+	if t.CPUSensor != "" && temperatures.CPUSensor == "" && len(chips) > 0 {
+		return temperatures, fmt.Errorf("no temperature sensor \"%s\"", t.CPUSensor)
+	}
+	return temperatures, nil
+}
+
+func findTemperature(chips []TemperatureChip, name string) (temperature float64, found bool) {
+	chipName, label, hasLabel := strings.Cut(name, "/")
+	for _, chip := range chips {
+		if chip.Name != chipName {
+			continue
+		}
+		if !hasLabel {
+			return chip.Max, len(chip.Sensors) > 0
+		}
+		for _, sensor := range chip.Sensors {
+			if sensor.Label == label {
+				return sensor.Temperature, true
+			}
+		}
+	}
+	return temperature, false
+}
+
+func (chip *TemperatureChip) addSensor(sensor TemperatureSensor) {
+	chip.Sensors = append(chip.Sensors, sensor)
+	if len(chip.Sensors) == 1 || sensor.Temperature > chip.Max {
+		chip.Max = sensor.Temperature
+	}
+}
+
+func readSysfsString(path string) string {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(contents))
+}
+
+func readMillidegrees(path string) (degrees float64, ok bool) {
+	value, err := strconv.ParseInt(readSysfsString(path), 10, 64)
+	if err != nil {
+		return degrees, false
+	}
+	return util.RoundTo(float64(value)/1000, 1), true
+}
+
+// sortByIndex sorts paths such as temp10_input after temp2_input
+func sortByIndex(paths []string, prefix string) {
+	index := func(path string) int {
+		digits := strings.TrimPrefix(filepath.Base(path), prefix)
+		if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+			digits = digits[:end]
+		}
+		n, _ := strconv.Atoi(digits)
+		return n
+	}
+	sort.SliceStable(paths, func(i, j int) bool { return index(paths[i]) < index(paths[j]) })
+}
@@ -0,0 +1,39 @@
+package stats
+
+import (
+	"github.com/gdanko/wsstats/util"
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// cpuSensors are tried in order when no primary CPU sensor is configured, the SMC keys of the CPU
+// diode, proximity and heatsink sensors
+var cpuSensors = []string{
+	"smc/TC0D",
+	"smc/TC0P",
+	"smc/TC0H",
+}
+
+// readTemperatureChips reads the SMC sensors through gopsutil as a single chip named smc, with a
+// sensor per SMC key. Keys the machine does not have read as 0 and are left out.
+func readTemperatureChips() (chips []TemperatureChip, err error) {
+	sensors, err := host.SensorsTemperatures()
+	if err != nil {
+		return chips, err
+	}
+	chip := TemperatureChip{Name: "smc", Device: "smc", Sensors: []TemperatureSensor{}, Fans: []FanData{}}
+	for _, sensor := range sensors {
+		if sensor.Temperature <= 0 {
+			continue
+		}
+		chip.addSensor(TemperatureSensor{
+			Label:       sensor.SensorKey,
+			Temperature: util.RoundTo(sensor.Temperature, 1),
+			High:        sensor.High,
+			Critical:    sensor.Critical,
+		})
+	}
+	if len(chip.Sensors) > 0 {
+		chips = append(chips, chip)
+	}
+	return chips, nil
+}
@@ -0,0 +1,138 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	hwmonPath   = "/sys/class/hwmon"
+	thermalPath = "/sys/class/thermal"
+)
+
+// cpuSensors are tried in order when no primary CPU sensor is configured
+var cpuSensors = []string{
+	"coretemp/Package id 0",
+	"k10temp/Tctl",
+	"k10temp/Tdie",
+	"zenpower/Tdie",
+	"cpu_thermal",
+	"x86_pkg_temp",
+	"acpitz",
+}
+
+func readTemperatureChips() (chips []TemperatureChip, err error) {
+	chips, err = readHwmonChips()
+	if err != nil {
+		return chips, err
+	}
+	zones, err := readThermalZones()
+	if err != nil {
+		return chips, err
+	}
+	return append(chips, zones...), nil
+}
+
+func readHwmonChips() (chips []TemperatureChip, err error) {
+	devices, err := os.ReadDir(hwmonPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chips, nil
+		}
+		return chips, err
+	}
+	for _, device := range devices {
+		dir := filepath.Join(hwmonPath, device.Name())
+		name := readSysfsString(filepath.Join(dir, "name"))
+		if name == "" {
+			// Older drivers keep their attributes in the device directory
+			dir = filepath.Join(dir, "device")
+			name = readSysfsString(filepath.Join(dir, "name"))
+		}
+		if name == "" {
+			name = device.Name()
+		}
+		chip := TemperatureChip{Name: name, Device: device.Name(), Sensors: []TemperatureSensor{}, Fans: []FanData{}}
+
+		inputs, _ := filepath.Glob(filepath.Join(dir, "temp*_input"))
+		sortByIndex(inputs, "temp")
+		for _, input := range inputs {
+			prefix := strings.TrimSuffix(input, "_input")
+			temperature, ok := readMillidegrees(input)
+			if !ok {
+				continue
+			}
+			label := readSysfsString(prefix + "_label")
+			if label == "" {
+				label = filepath.Base(prefix)
+			}
+			sensor := TemperatureSensor{Label: label, Temperature: temperature}
+			sensor.High, _ = readMillidegrees(prefix + "_max")
+			sensor.Critical, _ = readMillidegrees(prefix + "_crit")
+			chip.addSensor(sensor)
+		}
+
+		fans, _ := filepath.Glob(filepath.Join(dir, "fan*_input"))
+		sortByIndex(fans, "fan")
+		for _, input := range fans {
+			prefix := strings.TrimSuffix(input, "_input")
+			rpm, err := strconv.ParseUint(readSysfsString(input), 10, 64)
+			if err != nil {
+				continue
+			}
+			label := readSysfsString(prefix + "_label")
+			if label == "" {
+				label = filepath.Base(prefix)
+			}
+			chip.Fans = append(chip.Fans, FanData{Label: label, RPM: rpm})
+		}
+
+		if len(chip.Sensors) > 0 || len(chip.Fans) > 0 {
+			chips = append(chips, chip)
+		}
+	}
+	return chips, nil
+}
+
+// readThermalZones reads the thermal zones, which take their limits from the trip points
+func readThermalZones() (chips []TemperatureChip, err error) {
+	zones, err := filepath.Glob(filepath.Join(thermalPath, "thermal_zone*"))
+	if err != nil {
+		return chips, err
+	}
+	sortByIndex(zones, "thermal_zone")
+	for _, zone := range zones {
+		temperature, ok := readMillidegrees(filepath.Join(zone, "temp"))
+		if !ok {
+			continue
+		}
+		name := readSysfsString(filepath.Join(zone, "type"))
+		if name == "" {
+			name = filepath.Base(zone)
+		}
+		sensor := TemperatureSensor{Label: name, Temperature: temperature}
+		tripPoints, _ := filepath.Glob(filepath.Join(zone, "trip_point_*_type"))
+		for _, tripPoint := range tripPoints {
+			limit, ok := readMillidegrees(strings.TrimSuffix(tripPoint, "_type") + "_temp")
+			if !ok || limit <= 0 {
+				continue
+			}
+			switch readSysfsString(tripPoint) {
+			case "critical":
+				sensor.Critical = limit
+			case "hot":
+				sensor.High = limit
+			case "passive":
+				if sensor.High == 0 || limit < sensor.High {
+					sensor.High = limit
+				}
+			}
+		}
+		chip := TemperatureChip{Name: name, Device: filepath.Base(zone), Sensors: []TemperatureSensor{}, Fans: []FanData{}}
+		chip.addSensor(sensor)
+		chips = append(chips, chip)
+	}
+	return chips, nil
+}
@@ -0,0 +1,236 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TextfileData struct {
+	Directory string                  `json:"directory"`
+	Files     map[string]TextfileFile `json:"files"`
+}
+
+// TextfileFile is the content of a file, Data for a .json file and Metrics for a .prom file
+type TextfileFile struct {
+	Path     string           `json:"path"`
+	Modified int64            `json:"modified"`
+	Age      int64            `json:"age"`
+	Stale    bool             `json:"stale"`
+	Error    string           `json:"error,omitempty"`
+	Data     interface{}      `json:"data,omitempty"`
+	Metrics  []TextfileMetric `json:"metrics,omitempty"`
+}
+
+type TextfileMetric struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+type textfileEntry struct {
+	file     TextfileFile
+	modified time.Time
+	size     int64
+}
+
+// Textfiles reads the *.json and *.prom files other tools write to Directory, keyed by their name
+// without the extension. Files are only read again once they change, which inotify reports on
+// Linux. A file that was not modified for StaleAfter is stale, and a file that does not parse is
+// reported with its error rather than failing the collection.
+type Textfiles struct {
+	Directory  string
+	StaleAfter time.Duration
+	watch      *directoryWatch
+	entries    map[string]textfileEntry
+}
+
+func (t *Textfiles) Collect() (data TextfileData, err error) {
+	if t.watch == nil {
+		// Without a watch, e.g. while the directory does not exist yet, it is scanned every time
+		t.watch, _ = watchDirectory(t.Directory)
+	}
+	changed := t.watch == nil || t.watch.changed()
+	if t.watch != nil && t.watch.removed {
+		t.watch.close()
+		t.watch = nil
+	}
+	if t.entries == nil || changed {
+		if err = t.scan(); err != nil {
+			return data, err
+		}
+	}
+
+	now := time.Now()
+	data = TextfileData{Directory: t.Directory, Files: make(map[string]TextfileFile)}
+	for name, entry := range t.entries {
+		file := entry.file
+		file.Age = int64(now.Sub(entry.modified).Seconds())
+		file.Stale = t.StaleAfter > 0 && now.Sub(entry.modified) > t.StaleAfter
+		data.Files[name] = file
+	}
+	return data, nil
+}
+
+// Close stops watching the directory, the next call to Collect watches it again
+func (t *Textfiles) Close() {
+	if t.watch != nil {
+		t.watch.close()
+		t.watch = nil
+	}
+}
+
+// scan lists the directory and reads the files that are new or whose size or modification time changed
+func (t *Textfiles) scan() (err error) {
+	dirEntries, err := os.ReadDir(t.Directory)
+	if err != nil {
+		t.entries = nil
+		return err
+	}
+
+	entries := make(map[string]textfileEntry)
+	for _, dirEntry := range dirEntries {
+		extension := filepath.Ext(dirEntry.Name())
+		if dirEntry.IsDir() || (extension != ".json" && extension != ".prom") {
+			continue
+		}
+		path := filepath.Join(t.Directory, dirEntry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		// A backup.json and a backup.prom are told apart by the extension of the latter
+		name := strings.TrimSuffix(dirEntry.Name(), extension)
+		if extension == ".prom" {
+			if _, ok := entries[name]; ok {
+				name += "_prom"
+			}
+		}
+
+		if entry, ok := t.entries[name]; ok && entry.file.Path == path && entry.modified.Equal(info.ModTime()) && entry.size == info.Size() {
+			entries[name] = entry
+			continue
+		}
+		file := TextfileFile{Path: path, Modified: info.ModTime().Unix()}
+		contents, err := os.ReadFile(path)
+		if err == nil {
+			if extension == ".json" {
+				err = json.Unmarshal(contents, &file.Data)
+			} else {
+				file.Metrics, err = parsePrometheusText(contents)
+			}
+		}
+		if err != nil {
+			file.Error = err.Error()
+		}
+		entries[name] = textfileEntry{file: file, modified: info.ModTime(), size: info.Size()}
+	}
+	t.entries = entries
+	return nil
+}
+
+// parsePrometheusText parses the Prometheus text exposition format, skipping comments. Timestamps
+// are ignored, and NaN and infinite values are left out as JSON can not represent them.
+func parsePrometheusText(contents []byte) (metrics []TextfileMetric, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		metric := TextfileMetric{}
+		end := strings.IndexAny(text, "{ \t")
+		if end <= 0 {
+			return metrics, fmt.Errorf("line %d has no value", line)
+		}
+		metric.Name = text[:end]
+		rest := text[end:]
+		if rest[0] == '{' {
+			metric.Labels, rest, err = parsePrometheusLabels(rest[1:])
+			if err != nil {
+				return metrics, fmt.Errorf("line %d: %s", line, err.Error())
+			}
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 || len(fields) > 2 {
+			return metrics, fmt.Errorf("line %d is not \"name{labels} value [timestamp]\"", line)
+		}
+		metric.Value, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return metrics, fmt.Errorf("line %d has an invalid value \"%s\"", line, fields[0])
+		}
+		if math.IsNaN(metric.Value) || math.IsInf(metric.Value, 0) {
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, scanner.Err()
+}
+
+// parsePrometheusLabels parses the label="value" pairs following the opening brace and returns
+// what follows the closing one
+func parsePrometheusLabels(text string) (labels map[string]string, rest string, err error) {
+	labels = make(map[string]string)
+	for {
+		text = strings.TrimLeft(text, " \t,")
+		if strings.HasPrefix(text, "}") {
+			return labels, text[1:], nil
+		}
+		name, value, found := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		value = strings.TrimLeft(value, " \t")
+		if !found || name == "" || !strings.HasPrefix(value, "\"") {
+			return labels, rest, fmt.Errorf("invalid labels")
+		}
+
+		var builder strings.Builder
+		closed := false
+		i := 1
+		for ; i < len(value); i++ {
+			if value[i] == '"' {
+				closed = true
+				break
+			}
+			if value[i] == '\\' && i+1 < len(value) {
+				i++
+				switch value[i] {
+				case 'n':
+					builder.WriteByte('\n')
+				default:
+					builder.WriteByte(value[i])
+				}
+				continue
+			}
+			builder.WriteByte(value[i])
+		}
+		if !closed {
+			return labels, rest, fmt.Errorf("unterminated label value")
+		}
+		labels[name] = builder.String()
+		text = value[i+1:]
+	}
+}
+
+// SampleName names a metric by its name followed by its label values in the order of the label
+// names, e.g. backup_size_bytes.home for backup_size_bytes{target="home"}
+func (m TextfileMetric) SampleName() string {
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{m.Name}
+	for _, name := range names {
+		parts = append(parts, strings.ReplaceAll(m.Labels[name], " ", "_"))
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,18 @@
+package stats
+
+import "fmt"
+
+// directoryWatch is not implemented on macOS, the directory is scanned on every collection instead
+type directoryWatch struct {
+	removed bool
+}
+
+func watchDirectory(directory string) (watch *directoryWatch, err error) {
+	return nil, fmt.Errorf("watching a directory is only available on Linux")
+}
+
+func (w *directoryWatch) changed() bool {
+	return true
+}
+
+func (w *directoryWatch) close() {}
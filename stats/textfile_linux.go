@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// directoryWatch reports changes to the files of a directory through inotify
+type directoryWatch struct {
+	// file is the inotify descriptor, closed by close
+	file *os.File
+	// removed is set once the directory itself was deleted or moved, the watch is useless from then on
+	removed bool
+}
+
+func watchDirectory(directory string) (watch *directoryWatch, err error) {
+	fd, err := unix.InotifyInit1(unix.IN_NONBLOCK | unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	mask := uint32(unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_DELETE | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF)
+	if _, err = unix.InotifyAddWatch(fd, directory, mask); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &directoryWatch{file: os.NewFile(uintptr(fd), directory)}, nil
+}
+
+// changed drains the pending events and returns whether there were any
+func (w *directoryWatch) changed() bool {
+	changed := false
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := readNonBlocking(w.file, buffer)
+		if err != nil || n <= 0 {
+			if err != nil && !errors.Is(err, unix.EAGAIN) {
+				// Whatever went wrong, scanning the directory is always correct
+				w.removed = true
+				return true
+			}
+			return changed
+		}
+		changed = true
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			mask := binary.NativeEndian.Uint32(buffer[offset+4 : offset+8])
+			length := int(binary.NativeEndian.Uint32(buffer[offset+12 : offset+16]))
+			if mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+				w.removed = true
+			}
+			offset += unix.SizeofInotifyEvent + length
+		}
+	}
+}
+
+func (w *directoryWatch) close() {
+	w.file.Close()
+}
@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePrometheusText(t *testing.T) {
+	metrics, err := parsePrometheusText([]byte(`# HELP backup_size_bytes Size of the last backup
+# TYPE backup_size_bytes gauge
+backup_size_bytes{target="home"} 1024
+backup_size_bytes{target="media", host="nas \"1\""} 2048 1700000000000
+
+backup_last_success 1.7e9
+backup_duration_seconds NaN
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TextfileMetric{
+		{Name: "backup_size_bytes", Labels: map[string]string{"target": "home"}, Value: 1024},
+		{Name: "backup_size_bytes", Labels: map[string]string{"target": "media", "host": `nas "1"`}, Value: 2048},
+		{Name: "backup_last_success", Value: 1.7e9},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("parsed %+v, want %+v", metrics, want)
+	}
+	if name := metrics[1].SampleName(); name != `backup_size_bytes.nas_"1".media` {
+		t.Errorf("sample name %s", name)
+	}
+
+	for _, text := range []string{
+		"backup_last_success\n",
+		"backup_last_success yesterday\n",
+		"backup_size_bytes{target=\"home} 1\n",
+		"backup_size_bytes{target=home} 1\n",
+		"backup_size_bytes 1 2 3\n",
+	} {
+		if _, err := parsePrometheusText([]byte("# ok\nup 1\n" + text)); err == nil {
+			t.Errorf("expected an error for %q", text)
+		}
+	}
+}
+
+func TestTextfilesStale(t *testing.T) {
+	directory := t.TempDir()
+	write := func(name, contents string, modified time.Time) {
+		path := filepath.Join(directory, name)
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	write("backup.prom", "backup_last_success 1\n", now.Add(-2*time.Hour))
+	write("ci.json", `{"status": "passed"}`, now)
+	write("broken.prom", "not a metric\n", now)
+
+	textfiles := &Textfiles{Directory: directory, StaleAfter: time.Hour}
+	data, err := textfiles.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup, ci, broken := data.Files["backup"], data.Files["ci"], data.Files["broken"]
+	if !backup.Stale || backup.Age < 7200 || len(backup.Metrics) != 1 {
+		t.Errorf("backup.prom modified 2 hours ago: %+v", backup)
+	}
+	if ci.Stale || !reflect.DeepEqual(ci.Data, map[string]interface{}{"status": "passed"}) {
+		t.Errorf("ci.json modified now: %+v", ci)
+	}
+	if broken.Error == "" || broken.Stale {
+		t.Errorf("broken.prom: %+v", broken)
+	}
+
+	// Updating the file makes it fresh again
+	write("backup.prom", "backup_last_success 2\n", now)
+	data, err = textfiles.Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup = data.Files["backup"]; backup.Stale || backup.Metrics[0].Value != 2 {
+		t.Errorf("backup.prom after the update: %+v", backup)
+	}
+
+	// A StaleAfter of 0 never marks a file as stale
+	write("backup.prom", "backup_last_success 3\n", now.Add(-48*time.Hour))
+	data, err = (&Textfiles{Directory: directory}).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Files["backup"].Stale {
+		t.Errorf("backup.prom is stale without StaleAfter: %+v", data.Files["backup"])
+	}
+}
@@ -0,0 +1,211 @@
+package stats
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// ProcessWatch matches the processes of one watch by exactly one of its criteria
+type ProcessWatch struct {
+	Name string
+	// Process matches the process name, or the base name of its executable as the name is cut to 15 characters
+	Process string
+	Cmdline *regexp.Regexp
+	PIDFile string
+	// Unit matches the processes in the cgroup of a systemd unit, e.g. postgresql.service
+	Unit string
+}
+
+type WatchData struct {
+	Name       string  `json:"name"`
+	Running    bool    `json:"running"`
+	Instances  int     `json:"instances"`
+	PIDs       []int   `json:"pids"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"`
+	Uptime     uint64  `json:"uptime"`
+	Restarts   uint64  `json:"restarts"`
+}
+
+type watchState struct {
+	pids     map[int]bool
+	seen     bool
+	restarts uint64
+}
+
+// ProcessWatches reports whether the watched processes are running along with their aggregate
+// usage, and counts the restarts it observes between calls to Collect
+type ProcessWatches struct {
+	Watches    []ProcessWatch
+	previous   map[int]processTicks
+	previousAt time.Time
+	state      map[string]*watchState
+}
+
+func (p *ProcessWatches) Collect() (watches []WatchData, err error) {
+	if p.state == nil {
+		p.state = make(map[string]*watchState)
+	}
+
+	processStats, err := readProcessStats()
+	if err != nil {
+		return watches, err
+	}
+	bootTime, err := readBootTime()
+	if err != nil {
+		return watches, err
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(p.previousAt).Seconds()
+	current := make(map[int]processTicks)
+	for _, stat := range processStats {
+		current[stat.pid] = processTicks{ticks: stat.ticks, startTime: stat.startTime}
+	}
+
+	watches = make([]WatchData, 0, len(p.Watches))
+	for _, watch := range p.Watches {
+		data := WatchData{Name: watch.Name, PIDs: []int{}}
+		pidFilePID := 0
+		if watch.PIDFile != "" {
+			pidFilePID = readPIDFile(watch.PIDFile)
+		}
+
+		var oldestStart uint64
+		for _, stat := range processStats {
+			if !watch.matches(stat, pidFilePID) {
+				continue
+			}
+			data.PIDs = append(data.PIDs, stat.pid)
+			data.CPUPercent += cpuPercentSince(stat, p.previous, elapsed)
+			data.RSS += stat.rss
+			if oldestStart == 0 || stat.startTime < oldestStart {
+				oldestStart = stat.startTime
+			}
+		}
+		data.CPUPercent = util.RoundTo(data.CPUPercent, 2)
+		data.Instances = len(data.PIDs)
+		data.Running = data.Instances > 0
+		if data.Running {
+			started := bootTime + uint64(float64(oldestStart)/clockTicks)
+			if uint64(now.Unix()) > started {
+				data.Uptime = uint64(now.Unix()) - started
+			}
+		}
+		data.Restarts = p.countRestarts(watch.Name, data.PIDs)
+		watches = append(watches, data)
+	}
+
+	p.previous = current
+	p.previousAt = now
+	return watches, nil
+}
+
+// countRestarts counts a restart when a watch that was running before comes back up, or when
+// none of its processes survived since the previous call
+func (p *ProcessWatches) countRestarts(name string, pids []int) uint64 {
+	state, ok := p.state[name]
+	if !ok {
+		state = &watchState{}
+		p.state[name] = state
+	}
+
+	current := make(map[int]bool, len(pids))
+	survived := false
+	for _, pid := range pids {
+		current[pid] = true
+		if state.pids[pid] {
+			survived = true
+		}
+	}
+	if len(pids) > 0 && state.seen && !survived {
+		state.restarts++
+	}
+	if len(pids) > 0 {
+		state.seen = true
+	}
+	state.pids = current
+	return state.restarts
+}
+
+func (watch ProcessWatch) matches(stat processStat, pidFilePID int) bool {
+	switch {
+	case watch.Process != "":
+		if stat.name == watch.Process {
+			return true
+		}
+		// Names are cut to 15 characters, compare the executable instead
+		if len(stat.name) >= 15 && strings.HasPrefix(watch.Process, stat.name) {
+			executable, err := os.Readlink(filepath.Join(procPath, strconv.Itoa(stat.pid), "exe"))
+			return err == nil && filepath.Base(executable) == watch.Process
+		}
+		return false
+	case watch.Cmdline != nil:
+		return watch.Cmdline.MatchString(readProcessCmdline(stat.pid, 0))
+	case watch.PIDFile != "":
+		return pidFilePID > 0 && stat.pid == pidFilePID
+	case watch.Unit != "":
+		return processInUnit(stat.pid, watch.Unit)
+	}
+	return false
+}
+
+func readPIDFile(path string) int {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// processInUnit tells whether the cgroup of a process belongs to the unit, e.g.
+// 0::/system.slice/postgresql.service matches postgresql.service
+func processInUnit(pid int, unit string) bool {
+	f, err := os.Open(filepath.Join(procPath, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, segment := range strings.Split(parts[2], "/") {
+			if segment == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readBootTime returns the boot time in seconds since the epoch, which process start times are relative to
+func readBootTime() (bootTime uint64, err error) {
+	f, err := os.Open(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return bootTime, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "btime "); found {
+			return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	return bootTime, scanner.Err()
+}
@@ -0,0 +1,135 @@
+package stats
+
+import (
+	"bufio"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdanko/wsstats/util"
+)
+
+// WirelessData describes the link of a wireless interface, it is reported on that interface in the
+// network section. Signal and Noise are in dBm, Frequency in MHz and Bitrate, the transmit rate, in
+// Mbit/s. The SSID, frequency and bitrate come from nl80211 and are left empty where it is not
+// available.
+type WirelessData struct {
+	Interface string  `json:"-"`
+	Connected bool    `json:"connected"`
+	SSID      string  `json:"ssid"`
+	Quality   float64 `json:"quality"`
+	Signal    float64 `json:"signal"`
+	Noise     float64 `json:"noise"`
+	Frequency uint32  `json:"frequency"`
+	Bitrate   float64 `json:"bitrate"`
+}
+
+// nl80211Info is what nl80211 reports about the station an interface is associated with
+type nl80211Info struct {
+	ssid      string
+	frequency uint32
+	bitrate   float64
+	signal    int
+}
+
+type wirelessStats struct {
+	link  float64
+	level float64
+	noise float64
+}
+
+// GetWirelessData reports every wireless interface, in quality percent, signal level and, where
+// nl80211 is available, the network and rate it is connected at
+func GetWirelessData() (wireless []WirelessData, err error) {
+	wireless = []WirelessData{}
+	entries, err := os.ReadDir(sysClassNetPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return wireless, nil
+		}
+		return wireless, err
+	}
+	procStats, err := readProcWireless()
+	if err != nil {
+		return wireless, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isWireless(name) {
+			continue
+		}
+		data := WirelessData{Interface: name}
+		if stats, ok := procStats[name]; ok {
+			// Drivers scale the link quality to 70
+			data.Quality = util.RoundTo(math.Min(stats.link/70*100, 100), 0)
+			data.Signal = stats.level
+			data.Noise = stats.noise
+		}
+		if netInterface, err := net.InterfaceByName(name); err == nil {
+			if info, err := readNl80211(netInterface.Index); err == nil {
+				data.SSID = info.ssid
+				data.Frequency = info.frequency
+				data.Bitrate = info.bitrate
+				if data.Signal == 0 && info.signal != 0 {
+					data.Signal = float64(info.signal)
+					// The scale of NetworkManager, -100 dBm is 0% and -50 dBm is 100%
+					data.Quality = math.Max(0, math.Min(100, 2*(data.Signal+100)))
+				}
+			}
+		}
+		data.Connected = data.SSID != "" || data.Signal != 0
+		wireless = append(wireless, data)
+	}
+	sort.Slice(wireless, func(i, j int) bool { return wireless[i].Interface < wireless[j].Interface })
+	return wireless, nil
+}
+
+func isWireless(name string) bool {
+	for _, marker := range []string{"wireless", "phy80211"} {
+		if _, err := os.Stat(filepath.Join(sysClassNetPath, name, marker)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readProcWireless parses /proc/net/wireless, which lists the associated interfaces after two
+// header lines, e.g. "wlan0: 0000   70.  -40.  -256 ..."
+func readProcWireless() (wireless map[string]wirelessStats, err error) {
+	wireless = make(map[string]wirelessStats)
+	f, err := os.Open(filepath.Join(procPath, "net", "wireless"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return wireless, nil
+		}
+		return wireless, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 0; scanner.Scan(); line++ {
+		if line < 2 {
+			continue
+		}
+		name, rest, found := strings.Cut(scanner.Text(), ":")
+		fields := strings.Fields(rest)
+		if !found || len(fields) < 4 {
+			continue
+		}
+		number := func(value string) float64 {
+			parsed, _ := strconv.ParseFloat(strings.TrimSuffix(value, "."), 64)
+			return parsed
+		}
+		wireless[strings.TrimSpace(name)] = wirelessStats{
+			link:  number(fields[1]),
+			level: number(fields[2]),
+			noise: number(fields[3]),
+		}
+	}
+	return wireless, scanner.Err()
+}
@@ -0,0 +1,394 @@
+package store
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tier is one resolution level of the store. Raw samples have a resolution of zero.
+type Tier struct {
+	Name       string
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Tiers are ordered from the finest to the coarsest resolution. Every rollup tier averages
+// the raw samples that fall into each of its buckets.
+var Tiers = []Tier{
+	{Name: "raw", Resolution: 0, Retention: time.Hour},
+	{Name: "1m", Resolution: time.Minute, Retention: 24 * time.Hour},
+	{Name: "15m", Resolution: 15 * time.Minute, Retention: 30 * 24 * time.Hour},
+}
+
+// CompactInterval is how often expired points should be trimmed from the tier files
+var CompactInterval = 5 * time.Minute
+
+type Point struct {
+	Timestamp uint64             `json:"t"`
+	Values    map[string]float64 `json:"v"`
+	// Counts is only written for a bucket that was still filling up when the store was closed, so
+	// that the next Open can carry on with it. The point written once the bucket is complete
+	// supersedes it.
+	Counts map[string]uint64 `json:"n,omitempty"`
+}
+
+type Sample struct {
+	Timestamp uint64
+	Metric    string
+	Value     float64
+}
+
+type bucket struct {
+	start  uint64
+	sums   map[string]float64
+	counts map[string]uint64
+}
+
+type Store struct {
+	Path    string
+	mutex   sync.Mutex
+	buckets []*bucket
+	closed  bool
+	// Held for the whole of a compaction, mutex only while it replaces a tier file
+	compacting sync.Mutex
+}
+
+func Open(dir string) (s *Store, err error) {
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the store directory \"%s\": %s", dir, err.Error())
+	}
+	s = &Store{
+		Path:    dir,
+		buckets: make([]*bucket, len(Tiers)),
+	}
+	for i, tier := range Tiers {
+		if tier.Resolution == 0 {
+			continue
+		}
+		points, err := s.read(tier, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 && points[len(points)-1].Counts != nil {
+			s.buckets[i] = resumeBucket(points[len(points)-1])
+		}
+	}
+	return s, nil
+}
+
+// Close writes the buckets that are still filling up to their tiers, nothing is recorded after it
+func (s *Store) Close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for i, tier := range Tiers {
+		if s.buckets[i] == nil {
+			continue
+		}
+		point := s.buckets[i].point()
+		point.Counts = s.buckets[i].counts
+		if writeErr := s.write(tier, point); writeErr != nil && err == nil {
+			err = writeErr
+		}
+	}
+	return err
+}
+
+func (s *Store) tierFile(tier Tier) string {
+	return filepath.Join(s.Path, tier.Name+".jsonl")
+}
+
+// Append records a set of raw samples and rolls them up into the coarser tiers
+func (s *Store) Append(timestamp uint64, values map[string]float64) (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(values) == 0 || s.closed {
+		return nil
+	}
+	for i, tier := range Tiers {
+		if tier.Resolution == 0 {
+			err = s.write(tier, Point{Timestamp: timestamp, Values: values})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		resolution := uint64(tier.Resolution.Seconds())
+		start := timestamp - (timestamp % resolution)
+		current := s.buckets[i]
+		if current != nil && current.start != start {
+			err = s.write(tier, current.point())
+			if err != nil {
+				return err
+			}
+			current = nil
+		}
+		if current == nil {
+			current = &bucket{start: start, sums: make(map[string]float64), counts: make(map[string]uint64)}
+			s.buckets[i] = current
+		}
+		for metric, value := range values {
+			current.sums[metric] += value
+			current.counts[metric]++
+		}
+	}
+	return nil
+}
+
+func (b *bucket) point() Point {
+	values := make(map[string]float64, len(b.sums))
+	for metric, sum := range b.sums {
+		values[metric] = sum / float64(b.counts[metric])
+	}
+	return Point{Timestamp: b.start, Values: values}
+}
+
+func resumeBucket(point Point) *bucket {
+	b := &bucket{start: point.Timestamp, sums: make(map[string]float64), counts: make(map[string]uint64)}
+	for metric, count := range point.Counts {
+		b.sums[metric] = point.Values[metric] * float64(count)
+		b.counts[metric] = count
+	}
+	return b
+}
+
+func (s *Store) write(tier Tier, point Point) (err error) {
+	jsonBytes, err := json.Marshal(point)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.tierFile(tier), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the \"%s\" tier: %s", tier.Name, err.Error())
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(jsonBytes, '\n'))
+	if err != nil {
+		return fmt.Errorf("failed to write to the \"%s\" tier: %s", tier.Name, err.Error())
+	}
+	return nil
+}
+
+// Compact rewrites every tier file without the points that are past its retention. It is meant to
+// run on its own schedule, a tier is read and rewritten while Append carries on, which is only held
+// up while the points appended in the meantime are copied and the file is replaced.
+func (s *Store) Compact(now uint64) (err error) {
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	for _, tier := range Tiers {
+		var cutoff uint64
+		if retention := uint64(tier.Retention.Seconds()); retention < now {
+			cutoff = now - retention
+		}
+		err = s.compactTier(tier, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to compact the \"%s\" tier: %s", tier.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (s *Store) compactTier(tier Tier, cutoff uint64) (err error) {
+	filename := s.tierFile(tier)
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	// Points are written whole under the mutex, the size taken under it ends on a line
+	s.mutex.Lock()
+	info, err := f.Stat()
+	s.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	points, err := readPoints(io.LimitReader(f, info.Size()), cutoff)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.Path, tier.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, point := range points {
+		err = encoder.Encode(point)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	// The points appended since the file was read
+	if _, err = f.Seek(info.Size(), io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(writer, f); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (s *Store) read(tier Tier, since uint64) (points []Point, err error) {
+	f, err := os.Open(s.tierFile(tier))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return points, nil
+		}
+		return points, fmt.Errorf("failed to read the \"%s\" tier: %s", tier.Name, err.Error())
+	}
+	defer f.Close()
+
+	points, err = readPoints(f, since)
+	if err != nil {
+		return points, fmt.Errorf("failed to read the \"%s\" tier: %s", tier.Name, err.Error())
+	}
+	return points, nil
+}
+
+// readPoints reads the points of a tier file from since on
+func readPoints(reader io.Reader, since uint64) (points []Point, err error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var point Point
+		if json.Unmarshal(scanner.Bytes(), &point) != nil {
+			// A partially written line from a crash, skip it
+			continue
+		}
+		if point.Timestamp < since {
+			continue
+		}
+		// A bucket written on Close and written again once complete
+		if len(points) > 0 && points[len(points)-1].Timestamp == point.Timestamp {
+			points[len(points)-1] = point
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, scanner.Err()
+}
+
+// TierFor returns the finest tier that still holds data going back the requested duration
+func TierFor(since time.Duration) Tier {
+	for _, tier := range Tiers {
+		if since <= tier.Retention {
+			return tier
+		}
+	}
+	return Tiers[len(Tiers)-1]
+}
+
+// Query returns the samples of every metric matching the pattern (see Match) recorded in
+// the last since. Every part of the period is read from the finest tier that holds it, the coarser
+// tiers only fill in what is older than the data of the finer ones. A day long query of an
+// instance that started an hour ago is thus answered from the raw tier.
+func (s *Store) Query(pattern string, since time.Duration, now uint64) (samples []Sample, err error) {
+	if _, err = path.Match(pattern, ""); err != nil {
+		return samples, fmt.Errorf("invalid metric pattern \"%s\"", pattern)
+	}
+	var cutoff uint64
+	if seconds := uint64(since.Seconds()); seconds < now {
+		cutoff = now - seconds
+	}
+
+	coarsest := TierFor(since)
+	var segments [][]Point
+	until := uint64(math.MaxUint64)
+	for _, tier := range Tiers {
+		points, err := s.read(tier, cutoff)
+		if err != nil {
+			return samples, err
+		}
+		// Only the buckets that end before the finer tier begins
+		resolution := uint64(tier.Resolution.Seconds())
+		var kept []Point
+		for _, point := range points {
+			if point.Timestamp+resolution <= until {
+				kept = append(kept, point)
+			}
+		}
+		if len(kept) > 0 {
+			segments = append(segments, kept)
+			until = kept[0].Timestamp
+		}
+		if tier.Name == coarsest.Name {
+			break
+		}
+	}
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		for _, point := range segments[i] {
+			var metrics []string
+			for metric := range point.Values {
+				if Match(pattern, metric) {
+					metrics = append(metrics, metric)
+				}
+			}
+			sort.Strings(metrics)
+			for _, metric := range metrics {
+				samples = append(samples, Sample{Timestamp: point.Timestamp, Metric: metric, Value: point.Values[metric]})
+			}
+		}
+	}
+	return samples, nil
+}
+
+// Metrics lists the metric names matching the pattern (see Match) recorded in the raw tier, all of
+// them when the pattern is empty
+func (s *Store) Metrics(pattern string) (metrics []string, err error) {
+	if _, err = path.Match(pattern, ""); err != nil {
+		return metrics, fmt.Errorf("invalid metric pattern \"%s\"", pattern)
+	}
+	points, err := s.read(Tiers[0], 0)
+	if err != nil || len(points) == 0 {
+		return metrics, err
+	}
+	for metric := range points[len(points)-1].Values {
+		if pattern == "" || Match(pattern, metric) {
+			metrics = append(metrics, metric)
+		}
+	}
+	sort.Strings(metrics)
+	return metrics, nil
+}
+
+// Match reports whether a metric name matches a shell pattern as path.Match does, except that "/"
+// is an ordinary character. Mount points are part of the disk metrics, "*" in disk.*.used_percent
+// has to match the "/home" of disk./home.used_percent.
+func Match(pattern, metric string) bool {
+	matched, _ := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(metric, "/", "\x00"))
+	return matched
+}
@@ -0,0 +1,334 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type appended struct {
+	timestamp uint64
+	values    map[string]float64
+}
+
+func TestBucketBoundaries(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		appends []appended
+		// The points of the 1m tier once the store is closed
+		want []Point
+	}{
+		{
+			name: "one bucket",
+			appends: []appended{
+				{60, map[string]float64{"load": 1}},
+				{119, map[string]float64{"load": 3}},
+			},
+			want: []Point{
+				{Timestamp: 60, Values: map[string]float64{"load": 2}, Counts: map[string]uint64{"load": 2}},
+			},
+		},
+		{
+			name: "a sample on the boundary starts the next bucket",
+			appends: []appended{
+				{59, map[string]float64{"load": 1}},
+				{60, map[string]float64{"load": 3}},
+				{61, map[string]float64{"load": 5}},
+			},
+			want: []Point{
+				{Timestamp: 0, Values: map[string]float64{"load": 1}},
+				{Timestamp: 60, Values: map[string]float64{"load": 4}, Counts: map[string]uint64{"load": 2}},
+			},
+		},
+		{
+			name: "metrics missing from some samples are averaged over their own samples",
+			appends: []appended{
+				{120, map[string]float64{"load": 2, "temperature": 40}},
+				{130, map[string]float64{"load": 4}},
+				{180, map[string]float64{"load": 6}},
+			},
+			want: []Point{
+				{Timestamp: 120, Values: map[string]float64{"load": 3, "temperature": 40}},
+				{Timestamp: 180, Values: map[string]float64{"load": 6}, Counts: map[string]uint64{"load": 1}},
+			},
+		},
+		{
+			name: "empty buckets are skipped",
+			appends: []appended{
+				{60, map[string]float64{"load": 1}},
+				{300, map[string]float64{"load": 2}},
+			},
+			want: []Point{
+				{Timestamp: 60, Values: map[string]float64{"load": 1}},
+				{Timestamp: 300, Values: map[string]float64{"load": 2}, Counts: map[string]uint64{"load": 1}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, sample := range test.appends {
+				if err = s.Append(sample.timestamp, sample.values); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			points, err := s.read(Tiers[1], 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(points, test.want) {
+				t.Errorf("1m tier %+v, want %+v", points, test.want)
+			}
+		})
+	}
+}
+
+func TestResumeAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []appended{
+		{60, map[string]float64{"load": 1}},
+		{70, map[string]float64{"load": 2}},
+	} {
+		if err = s.Append(sample.timestamp, sample.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing is recorded once closed
+	if err = s.Append(80, map[string]float64{"load": 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The bucket left filling up carries on in the next instance
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range []appended{
+		{90, map[string]float64{"load": 6}},
+		{120, map[string]float64{"load": 10}},
+	} {
+		if err = s.Append(sample.timestamp, sample.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	points, err := s.read(Tiers[1], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Point{{Timestamp: 60, Values: map[string]float64{"load": 3}}}
+	if !reflect.DeepEqual(points, want) {
+		t.Errorf("1m tier %+v, want %+v", points, want)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadDedupe(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		lines []string
+		since uint64
+		want  []Point
+	}{
+		{
+			name: "the complete bucket supersedes the one written on close",
+			lines: []string{
+				`{"t":60,"v":{"load":1},"n":{"load":1}}`,
+				`{"t":60,"v":{"load":2}}`,
+				`{"t":120,"v":{"load":3}}`,
+			},
+			want: []Point{
+				{Timestamp: 60, Values: map[string]float64{"load": 2}},
+				{Timestamp: 120, Values: map[string]float64{"load": 3}},
+			},
+		},
+		{
+			name: "a bucket still filling up when closed twice",
+			lines: []string{
+				`{"t":60,"v":{"load":1},"n":{"load":1}}`,
+				`{"t":60,"v":{"load":2},"n":{"load":2}}`,
+			},
+			want: []Point{
+				{Timestamp: 60, Values: map[string]float64{"load": 2}, Counts: map[string]uint64{"load": 2}},
+			},
+		},
+		{
+			name: "a partially written line is skipped",
+			lines: []string{
+				`{"t":60,"v":{"load":1}}`,
+				`{"t":120,"v":{"lo`,
+				`{"t":120,"v":{"load":3}}`,
+			},
+			want: []Point{
+				{Timestamp: 60, Values: map[string]float64{"load": 1}},
+				{Timestamp: 120, Values: map[string]float64{"load": 3}},
+			},
+		},
+		{
+			name: "points before since are left out",
+			lines: []string{
+				`{"t":60,"v":{"load":1}}`,
+				`{"t":120,"v":{"load":2},"n":{"load":1}}`,
+				`{"t":120,"v":{"load":3}}`,
+			},
+			since: 100,
+			want: []Point{
+				{Timestamp: 120, Values: map[string]float64{"load": 3}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Store{Path: t.TempDir()}
+			err := os.WriteFile(s.tierFile(Tiers[1]), []byte(strings.Join(test.lines, "\n")+"\n"), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			points, err := s.read(Tiers[1], test.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(points, test.want) {
+				t.Errorf("read %+v, want %+v", points, test.want)
+			}
+		})
+	}
+}
+
+func TestQuerySpanningTiers(t *testing.T) {
+	const now = 10 * 24 * 3600
+	s := &Store{Path: t.TempDir()}
+	for tier, lines := range map[string][]string{
+		// Rollups overlap the finer tiers, only the parts older than them are used
+		"15m": {
+			`{"t":777600,"v":{"load":1}}`,
+			`{"t":778500,"v":{"load":2}}`,
+			`{"t":859500,"v":{"load":3}}`,
+		},
+		"1m": {
+			`{"t":778800,"v":{"load":4}}`,
+			`{"t":859560,"v":{"load":5}}`,
+			`{"t":860340,"v":{"load":6}}`,
+		},
+		"raw": {
+			`{"t":860400,"v":{"load":7,"temperature":40}}`,
+			`{"t":863999,"v":{"load":8}}`,
+		},
+	} {
+		err := os.WriteFile(filepath.Join(s.Path, tier+".jsonl"), []byte(strings.Join(lines, "\n")+"\n"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		pattern string
+		since   time.Duration
+		want    []Sample
+	}{
+		{
+			name:    "within the raw retention",
+			pattern: "load",
+			since:   time.Hour,
+			want: []Sample{
+				{Timestamp: 860400, Metric: "load", Value: 7},
+				{Timestamp: 863999, Metric: "load", Value: 8},
+			},
+		},
+		{
+			name:    "a day ends with the raw tier",
+			pattern: "load",
+			since:   24 * time.Hour,
+			want: []Sample{
+				{Timestamp: 778800, Metric: "load", Value: 4},
+				{Timestamp: 859560, Metric: "load", Value: 5},
+				{Timestamp: 860340, Metric: "load", Value: 6},
+				{Timestamp: 860400, Metric: "load", Value: 7},
+				{Timestamp: 863999, Metric: "load", Value: 8},
+			},
+		},
+		{
+			name:    "every tier",
+			pattern: "*",
+			since:   3 * 24 * time.Hour,
+			want: []Sample{
+				{Timestamp: 777600, Metric: "load", Value: 1},
+				{Timestamp: 778800, Metric: "load", Value: 4},
+				{Timestamp: 859560, Metric: "load", Value: 5},
+				{Timestamp: 860340, Metric: "load", Value: 6},
+				{Timestamp: 860400, Metric: "load", Value: 7},
+				{Timestamp: 860400, Metric: "temperature", Value: 40},
+				{Timestamp: 863999, Metric: "load", Value: 8},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			samples, err := s.Query(test.pattern, test.since, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(samples, test.want) {
+				t.Errorf("queried %+v, want %+v", samples, test.want)
+			}
+		})
+	}
+}
+
+func TestCompact(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const hour = 3600
+	for _, timestamp := range []uint64{0, hour, 2 * hour} {
+		if err = s.Append(timestamp, map[string]float64{"load": float64(timestamp / hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Compact(2 * hour); err != nil {
+		t.Fatal(err)
+	}
+	// The raw tier keeps an hour, the 1m tier a day
+	raw, err := s.read(Tiers[0], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2 || raw[0].Timestamp != hour {
+		t.Errorf("raw tier after compaction %+v", raw)
+	}
+	rollup, err := s.read(Tiers[1], 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollup) != 2 || rollup[0].Timestamp != 0 {
+		t.Errorf("1m tier after compaction %+v", rollup)
+	}
+
+	// Appending goes on in the compacted files
+	if err = s.Append(2*hour+60, map[string]float64{"load": 5}); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err = s.read(Tiers[0], 0); err != nil || len(raw) != 3 {
+		t.Errorf("raw tier after appending %+v, %v", raw, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(s.Path, "*.tmp"))
+	if len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
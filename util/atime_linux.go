@@ -0,0 +1,16 @@
+package util
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// FileAccessTime returns the last access time of a file, or its modification time when the
+// platform does not report it
+func FileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Sec, stat.Atim.Nsec)
+	}
+	return info.ModTime()
+}
@@ -5,6 +5,10 @@ import (
 	"math"
 	"os"
 	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -23,6 +27,68 @@ func GetHomeDir() (path string, err error) {
 	return user.HomeDir, nil
 }
 
+// GetDataDir returns the wsstats directory under $XDG_DATA_HOME, falling back to ~/.local/share
+func GetDataDir() (path string, err error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := GetHomeDir()
+		if err != nil {
+			return path, err
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "wsstats"), nil
+}
+
+// GetConfigDir returns the wsstats directory under $XDG_CONFIG_HOME, falling back to ~/.config
+func GetConfigDir() (path string, err error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := GetHomeDir()
+		if err != nil {
+			return path, err
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, "wsstats"), nil
+}
+
+// GetRuntimeDir returns and creates the private directory for the lockfile, socket and output.
+// It lives under $XDG_RUNTIME_DIR and falls back to ~/.local/state, then to a per-UID directory in /tmp.
+func GetRuntimeDir() (path string, err error) {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir != "" && PathExistsAndIsWritable(runtimeDir) == nil {
+		path = filepath.Join(runtimeDir, "wsstats")
+	} else if homeDir, err := GetHomeDir(); err == nil && PathExistsAndIsWritable(homeDir) == nil {
+		path = filepath.Join(homeDir, ".local", "state", "wsstats")
+	} else {
+		path = filepath.Join(os.TempDir(), fmt.Sprintf("wsstats-%d", os.Getuid()))
+	}
+
+	err = os.MkdirAll(path, 0700)
+	if err != nil {
+		return path, fmt.Errorf("failed to create the runtime directory \"%s\": %s", path, err.Error())
+	}
+	// MkdirAll leaves an existing directory alone, make sure nobody else can read it
+	info, err := os.Lstat(path)
+	if err != nil {
+		return path, err
+	}
+	if !info.IsDir() {
+		return path, fmt.Errorf("the runtime directory \"%s\" is not a directory", path)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() {
+		return path, fmt.Errorf("the runtime directory \"%s\" is owned by another user", path)
+	}
+	if info.Mode().Perm() != 0700 {
+		err = os.Chmod(path, 0700)
+		if err != nil {
+			return path, fmt.Errorf("failed to set the permissions of the runtime directory \"%s\": %s", path, err.Error())
+		}
+	}
+	return path, nil
+}
+
 func PathExistsAndIsWritable(path string) (err error) {
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
@@ -64,3 +130,19 @@ func DeleteFile(filename string) (err error) {
 func RoundTo(n float64, decimals uint32) float64 {
 	return math.Round(n*math.Pow(10, float64(decimals))) / math.Pow(10, float64(decimals))
 }
+
+// ParseDuration behaves like time.ParseDuration but also accepts a plain number of days, e.g. "7d"
+func ParseDuration(value string) (duration time.Duration, err error) {
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(value, "d"), 64)
+		if err != nil {
+			return duration, fmt.Errorf("invalid duration \"%s\"", value)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	duration, err = time.ParseDuration(value)
+	if err != nil {
+		return duration, fmt.Errorf("invalid duration \"%s\"", value)
+	}
+	return duration, nil
+}
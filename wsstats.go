@@ -6,25 +6,41 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/gdanko/wsstats/config"
 	test_runner "github.com/gdanko/wsstats/gather"
 	"github.com/gdanko/wsstats/internal"
-	"github.com/gdanko/wsstats/iostat"
+	"github.com/gdanko/wsstats/lock"
 	"github.com/gdanko/wsstats/stats"
+	"github.com/gdanko/wsstats/store"
 	"github.com/gdanko/wsstats/util"
 	flags "github.com/jessevdk/go-flags"
-	"github.com/shirou/gopsutil/v3/load"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/sirupsen/logrus"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
 )
 
+var instanceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 type Wezterm struct {
 	PrintVersion   bool
+	Instance       string
+	ConfigFile     string
+	Config         config.Config
+	Reloads        chan struct{}
+	Activity       chan struct{}
+	LastRead       atomic.Int64
+	IdleAfter      time.Duration
 	All            bool
 	CPU            bool
 	Disk           bool
@@ -34,25 +50,79 @@ type Wezterm struct {
 	Net            bool
 	Swap           bool
 	Lockfile       string
+	Lock           *lock.Lock
 	OutputFile     string
+	Socket         string
+	Listener       net.Listener
+	LatestSnapshot []byte
+	LatestOutput   map[string]interface{}
+	SnapshotLock   sync.RWMutex
+	MetricsServer  *http.Server
+	MetricsAddress string
 	StartTime      uint64
 	Logger         *logrus.Logger
 	Logfile        string
 	LogfileHandle  *os.File
 	RunTimeCurrent uint64
-	IostatDataOld  test_runner.IOStatData
+	Enabled        []string
+	CollectorNames []string
+	Intervals      map[string]string
+	OutputInterval time.Duration
+	Scheduler      *test_runner.Scheduler
+	NoHistory      bool
+	CpuInterval    time.Duration
+	Store          *store.Store
+	Command        flags.Commander
+	CommandArgs    []string
+	StartArgs      []string
 }
 
 type Options struct {
-	All          bool `short:"a" long:"all" description:"Report all available system info (default)"`
-	CPU          bool `short:"c" long:"cpu" description:"Report system CPU usage"`
-	Disk         bool `short:"d" long:"disk" description:"Report system disk usage"`
-	Host         bool `long:"host" description:"Report system host information"`
-	Load         bool `short:"l" long:"load" description:"Report system load averages"`
-	Memory       bool `short:"m" long:"memory" description:"Report system memory usage"`
-	Net          bool `short:"n" long:"network" description:"Report network throughput information"`
-	Swap         bool `short:"s" long:"swap" description:"Report swap memory usage"`
-	PrintVersion bool `short:"V" long:"version" description:"Print program version"`
+	All          bool              `short:"a" long:"all" description:"Report all available system info (default)"`
+	CPU          bool              `short:"c" long:"cpu" description:"Report system CPU usage"`
+	Disk         bool              `short:"d" long:"disk" description:"Report system disk usage"`
+	Host         bool              `long:"host" description:"Report system host information"`
+	Load         bool              `short:"l" long:"load" description:"Report system load averages"`
+	Memory       bool              `short:"m" long:"memory" description:"Report system memory usage"`
+	Net          bool              `short:"n" long:"network" description:"Report network throughput information"`
+	Swap         bool              `short:"s" long:"swap" description:"Report swap memory usage"`
+	NoHistory    bool              `long:"no-history" description:"Do not record samples in the local time-series store"`
+	Collectors   []string          `short:"C" long:"collector" description:"Enable a collector by name, can be repeated"`
+	Intervals    map[string]string `long:"interval" value-name:"NAME:DURATION" description:"How often a collector runs, e.g. disk:1m, can be repeated"`
+	Instance     string            `short:"i" long:"instance" default:"default" description:"Name of the instance, each instance has its own configuration, output and lockfile"`
+	Config       string            `long:"config" description:"Path to the configuration file (default: $XDG_CONFIG_HOME/wsstats/<instance>.json)"`
+	PrintVersion bool              `short:"V" long:"version" description:"Print program version"`
+}
+
+// Args returns the command line options that start an instance with these options
+func (o Options) Args() (args []string) {
+	for _, option := range []struct {
+		set  bool
+		name string
+	}{
+		{o.All, "--all"}, {o.CPU, "--cpu"}, {o.Disk, "--disk"}, {o.Host, "--host"}, {o.Load, "--load"},
+		{o.Memory, "--memory"}, {o.Net, "--network"}, {o.Swap, "--swap"}, {o.NoHistory, "--no-history"},
+	} {
+		if option.set {
+			args = append(args, option.name)
+		}
+	}
+	for _, collector := range o.Collectors {
+		args = append(args, "--collector", collector)
+	}
+	names := make([]string, 0, len(o.Intervals))
+	for name := range o.Intervals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--interval", name+":"+o.Intervals[name])
+	}
+	args = append(args, "--instance", o.Instance)
+	if o.Config != "" {
+		args = append(args, "--config", o.Config)
+	}
+	return args
 }
 
 func (w *Wezterm) init(args []string) error {
@@ -64,8 +134,22 @@ func (w *Wezterm) init(args []string) error {
 
 	opts = Options{}
 	parser = flags.NewParser(&opts, flags.Default)
-	parser.Usage = `[OPTIONS] 
-  wsstats gathers and writes system statistics in a way easily consumable by WezTerm`
+	parser.Usage = "[OPTIONS]"
+	parser.LongDescription = "wsstats gathers and writes system statistics in a way easily consumable by WezTerm"
+	parser.SubcommandsOptional = true
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		// Commands are run from main once the logger is set up
+		w.Command = command
+		w.CommandArgs = args
+		return nil
+	}
+	parser.AddCommand("get", "Print a value from the running instance", "Read the latest snapshot of the running instance and print the value at the given path", &GetCommand{w: w})
+	parser.AddCommand("status", "Show the status of the running instance", "Report the PID, version, uptime and enabled collectors of the running instance, with how long the latest run of each collector took", &StatusCommand{w: w})
+	parser.AddCommand("stop", "Stop the running instance", "Send SIGTERM to the running instance and wait for it to exit", &StopCommand{w: w})
+	parser.AddCommand("reload", "Reload the running instance", "Send SIGHUP to the running instance so it rereads its configuration and reopens its log file", &ReloadCommand{w: w})
+	parser.AddCommand("restart", "Restart the running instance", "Stop the running instance and start it again with the same arguments, or start one when none is running", &RestartCommand{w: w})
+	parser.AddCommand("once", "Collect a single snapshot", "Collect the selected sections once, print them to stdout and exit. The snapshot is printed as JSON, -f metrics prints only its numbers.", &OnceCommand{w: w})
+	parser.AddCommand("query", "Query the local time-series store", "Print the recorded history of one or more metrics", &QueryCommand{w: w})
 	if _, err := parser.Parse(); err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
@@ -74,6 +158,10 @@ func (w *Wezterm) init(args []string) error {
 		}
 	}
 
+	if !instanceNamePattern.MatchString(opts.Instance) {
+		return fmt.Errorf("invalid instance name \"%s\", use letters, digits, \"-\" and \"_\"", opts.Instance)
+	}
+
 	w.All = opts.All
 	w.CPU = opts.CPU
 	w.Disk = opts.Disk
@@ -82,15 +170,69 @@ func (w *Wezterm) init(args []string) error {
 	w.Memory = opts.Memory
 	w.Net = opts.Net
 	w.Swap = opts.Swap
-	w.Lockfile = "/tmp/wsstats.lock"
-	w.Logfile = "/tmp/wsstats.log"
+	w.CollectorNames = opts.Collectors
+	w.Intervals = opts.Intervals
+	w.Instance = opts.Instance
+	w.ConfigFile = opts.Config
+	w.StartArgs = opts.Args()
 	w.Logger = logrus.New()
-	w.OutputFile = "/tmp/wsstats.json"
+	w.Scheduler = test_runner.NewScheduler(w.Logger)
 	w.PrintVersion = opts.PrintVersion
 	w.StartTime = util.GetTimestamp()
+	w.CpuInterval = 1 * time.Second
+	w.OutputInterval = 1 * time.Second
+	w.Reloads = make(chan struct{}, 1)
+	w.Activity = make(chan struct{}, 1)
+	w.LastRead.Store(time.Now().UnixNano())
+
+	runtimeDir, err := util.GetRuntimeDir()
+	if err != nil {
+		return err
+	}
+	w.Lockfile = filepath.Join(runtimeDir, w.Instance+".lock")
+	w.Logfile = filepath.Join(runtimeDir, w.Instance+".log")
+	w.OutputFile = filepath.Join(runtimeDir, w.Instance+".json")
+	w.Socket = filepath.Join(runtimeDir, w.Instance+".sock")
+
+	if w.Command != nil {
+		// Commands report to the terminal rather than to the daemon's log file
+		w.Logger.SetOutput(os.Stderr)
+		w.Logger.SetLevel(logrus.WarnLevel)
+	}
+	// Only once collects, the other commands act on the running instance or its history and have
+	// to keep working when its configuration was edited into a broken state
+	if _, collects := w.Command.(*OnceCommand); w.Command != nil && !collects {
+		return nil
+	}
+
+	if w.ConfigFile == "" {
+		w.ConfigFile, err = config.DefaultPath(w.Instance)
+		if err != nil {
+			return err
+		}
+	}
+	w.Config, err = config.Load(w.ConfigFile)
+	if err != nil {
+		return err
+	}
+	w.NoHistory = opts.NoHistory || w.Config.NoHistory
+	w.IdleAfter, err = w.Config.Adaptive.Validate()
+	if err != nil {
+		return err
+	}
+	if w.Config.OutputFile != "" {
+		w.OutputFile = w.Config.OutputFile
+	}
+	if w.Config.Logfile != "" {
+		w.Logfile = w.Config.Logfile
+	}
 
-	if len(args) == 1 || w.All {
-		w.CPU, w.Disk, w.Host, w.Load, w.Memory, w.Net, w.Swap = true, true, true, true, true, true, true
+	err = w.SelectCollectors()
+	if err != nil {
+		return err
+	}
+	if w.Command != nil {
+		return nil
 	}
 
 	w.LogfileHandle, err = os.OpenFile(w.Logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
@@ -112,6 +254,10 @@ func (w *Wezterm) init(args []string) error {
 }
 
 func (w *Wezterm) ExitError(errorMessage error) {
+	if w.Command != nil {
+		fmt.Fprintf(os.Stderr, "wsstats: %s\n", errorMessage.Error())
+		os.Exit(1)
+	}
 	w.CleanUp()
 	w.Logger.Error(errorMessage.Error())
 	w.LogfileHandle.Close()
@@ -119,21 +265,80 @@ func (w *Wezterm) ExitError(errorMessage error) {
 }
 
 func (w *Wezterm) ExitCleanly() {
+	if w.Command != nil {
+		os.Exit(0)
+	}
 	w.CleanUp()
 	w.LogfileHandle.Close()
 	os.Exit(0)
 }
 
+func (w *Wezterm) OpenStore() (s *store.Store, err error) {
+	dataDir, err := util.GetDataDir()
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(filepath.Join(dataDir, w.Instance))
+}
+
 func (w *Wezterm) CreateLockfile() (err error) {
-	f, err := os.Create(w.Lockfile)
+	executable, err := os.Executable()
 	if err != nil {
-		return fmt.Errorf("failed to create the lockfile \"%s\"", w.Lockfile)
+		return fmt.Errorf("failed to determine the path of the running executable: %s", err.Error())
+	}
+	l, stale, err := lock.Acquire(w.Lockfile, lock.Info{
+		PID:        os.Getpid(),
+		StartTime:  w.StartTime,
+		Version:    internal.Version(false, true),
+		Executable: executable,
+		Args:       os.Args[1:],
+		OutputFile: w.OutputFile,
+	})
+	if err != nil {
+		return err
 	}
-	defer f.Close()
+	w.Lock = l
+	if stale != nil {
+		w.Logger.Warnf("reclaimed the stale lockfile \"%s\" left behind by PID %d", w.Lockfile, stale.PID)
+	}
+	return nil
+}
+
+// Reload rereads the configuration file and reopens the log file, e.g. after it was rotated. The
+// paths of the output, socket and lockfile stay as they were when the instance started.
+func (w *Wezterm) Reload() (err error) {
+	cfg, err := config.Load(w.ConfigFile)
+	if err != nil {
+		return err
+	}
+	idleAfter, err := cfg.Adaptive.Validate()
+	if err != nil {
+		return err
+	}
+	previous := w.Config
+	w.Config = cfg
+	err = w.SelectCollectors()
+	if err != nil {
+		w.Config = previous
+		return err
+	}
+	w.IdleAfter = idleAfter
 
+	logfileHandle, err := os.OpenFile(w.Logfile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create the log file handle: %s", err.Error())
+	}
+	w.Logger.SetOutput(logfileHandle)
+	w.LogfileHandle.Close()
+	w.LogfileHandle = logfileHandle
 	return nil
 }
 
+// EnabledCollectors returns the names of the sections the instance collects
+func (w *Wezterm) EnabledCollectors() (collectors []string) {
+	return w.Enabled
+}
+
 func (w *Wezterm) ShowVersion() {
 	fmt.Fprintf(os.Stdout, "wsstats version %s\n", internal.Version(false, true))
 }
@@ -141,93 +346,125 @@ func (w *Wezterm) ShowVersion() {
 func (w *Wezterm) ProcessOutput(WeztermStatsData map[string]interface{}) {
 	jsonBytes, err := json.MarshalIndent(WeztermStatsData, "", "    ")
 	if err != nil {
-		w.ExitError(err)
+		// Keep serving the previous snapshot rather than exiting
+		w.Logger.Errorf("failed to encode the snapshot: %s", err.Error())
+		return
 	}
 
-	// fmt.Println(string(jsonBytes))
+	w.SnapshotLock.Lock()
+	w.LatestSnapshot = jsonBytes
+	w.LatestOutput = WeztermStatsData
+	w.SnapshotLock.Unlock()
 
-	err = os.WriteFile(w.OutputFile, jsonBytes, 0644)
+	err = os.WriteFile(w.OutputFile, jsonBytes, 0600)
 	if err != nil {
 		w.ExitError(err)
 	}
 }
 
 func (w *Wezterm) CleanUp() {
-	for _, filename := range []string{w.OutputFile, w.Lockfile} {
+	stats.KillCommands()
+	// Without the lock the files belong to another instance
+	if w.Lock == nil {
+		return
+	}
+	if w.Listener != nil {
+		w.Listener.Close()
+	}
+	if w.MetricsServer != nil {
+		w.MetricsServer.Close()
+	}
+	if w.Store != nil {
+		err := w.Store.Close()
+		if err != nil {
+			w.Logger.Warn(err.Error())
+		}
+	}
+	for _, filename := range []string{w.OutputFile, w.Socket} {
 		err := util.DeleteFile(filename)
 		if err != nil {
 			w.Logger.Warn(err.Error())
 		}
 	}
+	err := w.Lock.Release()
+	if err != nil {
+		w.Logger.Warn(err.Error())
+	}
 }
 
-func (w *Wezterm) ParallelTester() (output map[string]interface{}) {
-	// Fetch all data at once using Go channels
+// Snapshot merges the latest result of every collector with the run metadata. Collector errors
+// are reported in the "errors" section rather than failing the whole snapshot.
+func (w *Wezterm) Snapshot() (output map[string]interface{}, errs map[string]error) {
+	w.RunTimeCurrent = util.GetTimestamp()
 	output = make(map[string]interface{})
-	if w.CPU {
-		cpuPercentChannel := make(chan func() ([]stats.PercentStat, error))
-		go test_runner.GetCpuPercent(cpuPercentChannel)
-		cpuPercent, err := (<-cpuPercentChannel)()
-		if err == nil {
-			output["cpu"] = cpuPercent
+	errs = make(map[string]error)
+
+	collectedAt := make(map[string]uint64)
+	// How long the latest run of each collector took, which for the collectors comparing two
+	// samples includes the time between them
+	durations := make(map[string]float64)
+	for name, result := range w.Scheduler.Results() {
+		if result.Err != nil {
+			errs[name] = result.Err
 		}
-	}
-
-	if w.Disk {
-		diskUsageChannel := make(chan func() ([]stats.DiskUsageData, error))
-		go test_runner.GetDiskUsage(diskUsageChannel)
-		diskUsage, err := (<-diskUsageChannel)()
-		if err == nil {
-			output["disk"] = diskUsage
-		}
-	}
-
-	if w.Host {
-		hostInformationChannel := make(chan func() (stats.HostInformation, error))
-		go test_runner.GetHostInformation(hostInformationChannel)
-		hostInformation, err := (<-hostInformationChannel)()
-		if err == nil {
-			output["host"] = hostInformation
+		if !result.CollectedAt.IsZero() {
+			// A section that can not be written as JSON, e.g. holding a NaN, would fail the whole
+			// snapshot, it is reported as an error instead
+			if _, err := json.Marshal(result.Data); err != nil {
+				errs[name] = fmt.Errorf("failed to encode the section: %s", err.Error())
+			} else {
+				output[name] = result.Data
+				collectedAt[name] = uint64(result.CollectedAt.Unix())
+			}
 		}
+		durations[name] = util.RoundTo(result.Duration.Seconds(), 3)
 	}
 
-	if w.Load {
-		loadAveragesChannel := make(chan func() (*load.AvgStat, error))
-		go test_runner.GetLoadAverages(loadAveragesChannel)
-		loadAverages, err := (<-loadAveragesChannel)()
-		if err == nil {
-			output["load"] = loadAverages
+	output["timestamp"] = w.RunTimeCurrent
+	output["start_time"] = w.StartTime
+	output["run_time"] = w.RunTimeCurrent - w.StartTime
+	output["collected_at"] = collectedAt
+	output["collect_durations"] = durations
+	output["pid"] = os.Getpid()
+	output["version"] = internal.Version(false, true)
+	output["collectors"] = w.EnabledCollectors()
+
+	if len(errs) > 0 {
+		errorMessages := make(map[string]string)
+		for section, err := range errs {
+			errorMessages[section] = err.Error()
 		}
+		output["errors"] = errorMessages
 	}
+	return output, errs
+}
 
-	if w.Memory {
-		memoryUsageChannel := make(chan func() (*mem.VirtualMemoryStat, error))
-		go test_runner.GetMemoryUsage(memoryUsageChannel)
-		memoryUsage, err := (<-memoryUsageChannel)()
-		if err == nil {
-			output["memory"] = memoryUsage
-		}
+// StartCollectors (re)starts the scheduler with the enabled collectors
+func (w *Wezterm) StartCollectors(ctx context.Context) (err error) {
+	collectors, err := w.Collectors()
+	if err != nil {
+		return err
 	}
+	w.Scheduler.Start(ctx, collectors)
+	return nil
+}
 
-	if w.Net {
-		networkThroughputChannel := make(chan func(logger *logrus.Logger, iostatDataOld test_runner.IOStatData) ([]test_runner.NetworkInterfaceData, test_runner.IOStatData, error))
-		go test_runner.GetNetworkThroughput(networkThroughputChannel)
-		networkThroughput, iostatDataNew, err := (<-networkThroughputChannel)(w.Logger, w.IostatDataOld)
-		if err == nil {
-			w.IostatDataOld = iostatDataNew
-			output["network"] = networkThroughput
+// CompactStore trims the expired points of the history on its own ticker, so that the output loop
+// does not wait for the tier files to be rewritten
+func (w *Wezterm) CompactStore(ctx context.Context) {
+	ticker := time.NewTicker(store.CompactInterval)
+	defer ticker.Stop()
+	for {
+		err := w.Store.Compact(util.GetTimestamp())
+		if err != nil {
+			w.Logger.Warn(err.Error())
 		}
-	}
-
-	if w.Swap {
-		swapUsageChannel := make(chan func() (*mem.SwapMemoryStat, error))
-		go test_runner.GetSwapUsage(swapUsageChannel)
-		swapUsage, err := (<-swapUsageChannel)()
-		if err == nil {
-			output["swap"] = swapUsage
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
-	return output
 }
 
 func Run(ctx context.Context, w *Wezterm) error {
@@ -236,41 +473,70 @@ func Run(ctx context.Context, w *Wezterm) error {
 		w.ExitCleanly()
 	}
 
-	if util.FileExists(w.Lockfile) {
-		return fmt.Errorf("the lockfile \"%s\" already exists - the program is probably already running", w.Lockfile)
+	err := w.CreateLockfile()
+	if err != nil {
+		return err
 	}
 
-	err := w.CreateLockfile()
+	err = w.ServeSocket()
 	if err != nil {
 		return err
 	}
 
-	// Get the first network sample
-	if w.Net {
-		data, err := iostat.GetData()
+	err = w.ServeMetrics()
+	if err != nil {
+		return err
+	}
+
+	if !w.NoHistory {
+		w.Store, err = w.OpenStore()
 		if err != nil {
 			return err
 		}
-		w.IostatDataOld.Interfaces = data
-		time.Sleep(1 * time.Second)
+		go w.CompactStore(ctx)
 	}
 
+	err = w.StartCollectors(ctx)
+	if err != nil {
+		return err
+	}
+	defer w.Scheduler.Stop()
+
+	// The first snapshot is written after one interval, which gives every collector time for its first run
+	ticker := time.NewTicker(w.OutputInterval)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		default:
-			w.RunTimeCurrent = util.GetTimestamp()
-
-			output := w.ParallelTester()
-			output["timestamp"] = w.RunTimeCurrent
-			output["start_time"] = w.StartTime
-			output["run_time"] = w.RunTimeCurrent - w.StartTime
+		case <-w.Reloads:
+			err = w.Reload()
+			if err == nil {
+				err = w.StartCollectors(ctx)
+			}
+			if err == nil {
+				err = w.ServeMetrics()
+			}
+			if err != nil {
+				w.Logger.Errorf("failed to reload: %s", err.Error())
+			}
+		case <-w.Activity:
+			w.AdaptPace()
+		case <-ticker.C:
+			w.checkOutputRead()
+			w.AdaptPace()
+			if _, paused := w.Scheduler.Pace(); paused {
+				continue
+			}
 
+			output, _ := w.Snapshot()
 			w.ProcessOutput(output)
 
-			if w.RunTimeCurrent == util.GetTimestamp() {
-				time.Sleep(1 * time.Second)
+			if w.Store != nil {
+				err = w.Store.Append(w.RunTimeCurrent, flattenSamples(output))
+				if err != nil {
+					w.Logger.Warn(err.Error())
+				}
 			}
 		}
 	}
@@ -283,25 +549,31 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	go func() {
-		select {
-		case s := <-signalChan:
-			switch s {
-			case syscall.SIGINT:
-				w.Logger.Info("Got SIGINT, exiting.")
-				w.ExitCleanly()
-			case syscall.SIGTERM:
-				w.Logger.Info("Got SIGTERM, exiting.")
+		for {
+			select {
+			case s := <-signalChan:
+				switch s {
+				case syscall.SIGINT:
+					w.Logger.Info("Got SIGINT, exiting.")
+					w.ExitCleanly()
+				case syscall.SIGTERM:
+					w.Logger.Info("Got SIGTERM, exiting.")
+					w.ExitCleanly()
+				case syscall.SIGHUP:
+					w.Logger.Info("Got SIGHUP, reloading.")
+					// The run loop picks the reload up between two cycles
+					select {
+					case w.Reloads <- struct{}{}:
+					default:
+					}
+				}
+			case <-ctx.Done():
+				w.Logger.Info("Exiting normally.")
 				w.ExitCleanly()
-			case syscall.SIGHUP:
-				w.Logger.Info("Got SIGHUP, reloading.")
-				w.init(os.Args)
 			}
-		case <-ctx.Done():
-			w.Logger.Info("Exiting normally.")
-			w.ExitCleanly()
 		}
 	}()
 
@@ -314,6 +586,13 @@ func main() {
 		w.ExitError(err)
 	}
 
+	if w.Command != nil {
+		if err := w.Command.Execute(w.CommandArgs); err != nil {
+			w.ExitError(err)
+		}
+		w.ExitCleanly()
+	}
+
 	if err := Run(ctx, w); err != nil {
 		w.ExitError(err)
 	}